@@ -0,0 +1,512 @@
+package memfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/absfs/inode"
+)
+
+// ACLTag identifies the kind of an ACL entry.
+type ACLTag int
+
+// ACL entry tags, matching the values used by libacl.
+const (
+	ACL_USER_OBJ  ACLTag = 0x01 // permissions of the file owner.
+	ACL_USER      ACLTag = 0x02 // permissions of a named user.
+	ACL_GROUP_OBJ ACLTag = 0x04 // permissions of the owning group.
+	ACL_GROUP     ACLTag = 0x08 // permissions of a named group.
+	ACL_MASK      ACLTag = 0x10 // upper bound for group class permissions.
+	ACL_OTHER     ACLTag = 0x20 // permissions of everyone else.
+)
+
+// String returns the long text form of the tag as used by getfacl.
+func (t ACLTag) String() string {
+	switch t {
+	case ACL_USER_OBJ, ACL_USER:
+		return "user"
+	case ACL_GROUP_OBJ, ACL_GROUP:
+		return "group"
+	case ACL_MASK:
+		return "mask"
+	case ACL_OTHER:
+		return "other"
+	}
+	return fmt.Sprintf("ACLTag(%d)", int(t))
+}
+
+// ACLEntry is a single entry of a POSIX access control list. Qualifier holds
+// the uid for ACL_USER entries and the gid for ACL_GROUP entries and is
+// ignored otherwise. Perm holds the read, write and execute bits (0-7).
+type ACLEntry struct {
+	Tag       ACLTag
+	Qualifier int
+	Perm      os.FileMode
+}
+
+// ACL is a POSIX access control list.
+type ACL []ACLEntry
+
+// ParseACL parses an ACL in the text form accepted by setfacl and produced by
+// getfacl. Entries are separated by commas or newlines, tags may be given in
+// their long (`user`) or short (`u`) form, and comments beginning with `#`
+// are ignored. Qualifiers must be numeric ids since memfs has no user
+// database. Entries prefixed with `default:` are rejected; use ParseFacl to
+// parse getfacl output that includes a default ACL.
+func ParseACL(text string) (ACL, error) {
+	access, def, err := ParseFacl(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(def) > 0 {
+		return nil, fmt.Errorf("unexpected default ACL entry in %q", text)
+	}
+	return access, nil
+}
+
+// ParseFacl parses the output of getfacl, returning the access ACL and the
+// default ACL entries (those prefixed with `default:` or `d:`) separately.
+func ParseFacl(text string) (access ACL, def ACL, err error) {
+	for _, line := range strings.Split(text, "\n") {
+		if x := strings.Index(line, "#"); x != -1 {
+			line = line[:x]
+		}
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			isDefault := false
+			for _, prefix := range []string{"default:", "d:"} {
+				if strings.HasPrefix(field, prefix) {
+					field = strings.TrimPrefix(field, prefix)
+					isDefault = true
+					break
+				}
+			}
+			entry, err := parseACLEntry(field)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isDefault {
+				def = append(def, entry)
+				continue
+			}
+			access = append(access, entry)
+		}
+	}
+	return access, def, nil
+}
+
+func parseACLEntry(text string) (ACLEntry, error) {
+	var entry ACLEntry
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 {
+		return entry, fmt.Errorf("invalid ACL entry %q", text)
+	}
+	tag, qualifier, perm := parts[0], strings.TrimSpace(parts[1]), parts[2]
+
+	switch tag {
+	case "user", "u":
+		entry.Tag = ACL_USER_OBJ
+		if qualifier != "" {
+			entry.Tag = ACL_USER
+		}
+	case "group", "g":
+		entry.Tag = ACL_GROUP_OBJ
+		if qualifier != "" {
+			entry.Tag = ACL_GROUP
+		}
+	case "mask", "m":
+		entry.Tag = ACL_MASK
+	case "other", "o":
+		entry.Tag = ACL_OTHER
+	default:
+		return entry, fmt.Errorf("invalid ACL entry tag %q", text)
+	}
+
+	if qualifier != "" {
+		if entry.Tag != ACL_USER && entry.Tag != ACL_GROUP {
+			return entry, fmt.Errorf("unexpected ACL entry qualifier %q", text)
+		}
+		id, err := strconv.Atoi(qualifier)
+		if err != nil || id < 0 {
+			return entry, fmt.Errorf("invalid ACL entry qualifier %q", text)
+		}
+		entry.Qualifier = id
+	}
+
+	p, err := parseACLPerm(strings.TrimSpace(perm))
+	if err != nil {
+		return entry, fmt.Errorf("invalid ACL entry permissions %q", text)
+	}
+	entry.Perm = p
+	return entry, nil
+}
+
+func parseACLPerm(text string) (os.FileMode, error) {
+	if len(text) == 1 && text[0] >= '0' && text[0] <= '7' {
+		return os.FileMode(text[0] - '0'), nil
+	}
+	var perm os.FileMode
+	for _, c := range text {
+		switch c {
+		case 'r':
+			perm |= 04
+		case 'w':
+			perm |= 02
+		case 'x':
+			perm |= 01
+		case '-':
+		default:
+			return 0, syscall.EINVAL
+		}
+	}
+	return perm, nil
+}
+
+// String returns the ACL in the long text form produced by getfacl, one entry
+// per line in canonical order.
+func (a ACL) String() string {
+	var b strings.Builder
+	for _, e := range a.sorted() {
+		b.WriteString(e.Tag.String())
+		b.WriteByte(':')
+		if e.Tag == ACL_USER || e.Tag == ACL_GROUP {
+			b.WriteString(strconv.Itoa(e.Qualifier))
+		}
+		b.WriteByte(':')
+		b.WriteString(aclPermString(e.Perm))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func aclPermString(perm os.FileMode) string {
+	buf := []byte("---")
+	for i, c := range "rwx" {
+		if perm&(04>>uint(i)) != 0 {
+			buf[i] = byte(c)
+		}
+	}
+	return string(buf)
+}
+
+// sorted returns a copy of the ACL in canonical order.
+func (a ACL) sorted() ACL {
+	list := make(ACL, len(a))
+	copy(list, a)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Tag != list[j].Tag {
+			return list[i].Tag < list[j].Tag
+		}
+		return list[i].Qualifier < list[j].Qualifier
+	})
+	return list
+}
+
+// find returns the first entry with the given tag.
+func (a ACL) find(tag ACLTag) (ACLEntry, bool) {
+	for _, e := range a {
+		if e.Tag == tag {
+			return e, true
+		}
+	}
+	return ACLEntry{}, false
+}
+
+// extended reports whether the ACL has entries beyond those represented by
+// the permission bits of the file mode.
+func (a ACL) extended() bool {
+	for _, e := range a {
+		if e.Tag == ACL_USER || e.Tag == ACL_GROUP || e.Tag == ACL_MASK {
+			return true
+		}
+	}
+	return false
+}
+
+// valid reports whether the ACL is well formed: exactly one owner, owning
+// group and other entry, no duplicate qualifiers and a mask entry if there are
+// any named user or group entries.
+func (a ACL) valid() bool {
+	count := make(map[ACLTag]int)
+	seen := make(map[ACLEntry]bool)
+	for _, e := range a {
+		if e.Perm&^07 != 0 {
+			return false
+		}
+		count[e.Tag]++
+		switch e.Tag {
+		case ACL_USER, ACL_GROUP:
+			key := ACLEntry{Tag: e.Tag, Qualifier: e.Qualifier}
+			if seen[key] {
+				return false
+			}
+			seen[key] = true
+		case ACL_USER_OBJ, ACL_GROUP_OBJ, ACL_MASK, ACL_OTHER:
+		default:
+			return false
+		}
+	}
+	if count[ACL_USER_OBJ] != 1 || count[ACL_GROUP_OBJ] != 1 || count[ACL_OTHER] != 1 || count[ACL_MASK] > 1 {
+		return false
+	}
+	if (count[ACL_USER] > 0 || count[ACL_GROUP] > 0) && count[ACL_MASK] == 0 {
+		return false
+	}
+	return true
+}
+
+// GetACL returns the access ACL of the named file, following symbolic links.
+// Files without an extended ACL report the minimal ACL equivalent to their
+// permission bits.
+func (fs *FileSystem) GetACL(name string) (ACL, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	node, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "getacl", Path: name, Err: err}
+	}
+	return fs.accessACL(node), nil
+}
+
+// SetACL replaces the access ACL of the named file, following symbolic links.
+// The owner, group class and other permission bits of the file mode are
+// updated to match the ACL.
+func (fs *FileSystem) SetACL(name string, acl ACL) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node, err := fs.resolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "setacl", Path: name, Err: err}
+	}
+	if !acl.valid() {
+		return &os.PathError{Op: "setacl", Path: name, Err: syscall.EINVAL}
+	}
+	if fs.rdonly.Load() {
+		return &os.PathError{Op: "setacl", Path: name, Err: syscall.EROFS}
+	}
+	if !fs.owns(node) {
+		return &os.PathError{Op: "setacl", Path: name, Err: syscall.EPERM}
+	}
+	fs.setAccessACL(node, acl)
+	fs.changed(node)
+	return nil
+}
+
+// GetDefaultACL returns the default ACL of the named directory, or nil if it
+// has none.
+func (fs *FileSystem) GetDefaultACL(name string) (ACL, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	node, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "getacl", Path: name, Err: err}
+	}
+	return fs.defaultACLs[node.Ino].sorted(), nil
+}
+
+// SetDefaultACL replaces the default ACL of the named directory. The default
+// ACL is inherited by files and directories subsequently created in it. An
+// empty ACL removes the default ACL.
+func (fs *FileSystem) SetDefaultACL(name string, acl ACL) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node, err := fs.resolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "setacl", Path: name, Err: err}
+	}
+	if !node.IsDir() {
+		return &os.PathError{Op: "setacl", Path: name, Err: syscall.EACCES}
+	}
+	if fs.rdonly.Load() {
+		return &os.PathError{Op: "setacl", Path: name, Err: syscall.EROFS}
+	}
+	if !fs.owns(node) {
+		return &os.PathError{Op: "setacl", Path: name, Err: syscall.EPERM}
+	}
+	if len(acl) == 0 {
+		delete(fs.defaultACLs, node.Ino)
+		fs.changed(node)
+		return nil
+	}
+	if !acl.valid() {
+		return &os.PathError{Op: "setacl", Path: name, Err: syscall.EINVAL}
+	}
+	fs.defaultACLs[node.Ino] = acl.sorted()
+	fs.changed(node)
+	return nil
+}
+
+// accessACL returns the access ACL of node. The owner, other and group class
+// entries always reflect the current permission bits so that Chmod keeps the
+// ACL in sync.
+func (fs *FileSystem) accessACL(node *inode.Inode) ACL {
+	mode := node.Mode.Perm()
+	stored, ok := fs.acls[node.Ino]
+	if !ok {
+		return ACL{
+			{Tag: ACL_USER_OBJ, Perm: mode >> 6 & 07},
+			{Tag: ACL_GROUP_OBJ, Perm: mode >> 3 & 07},
+			{Tag: ACL_OTHER, Perm: mode & 07},
+		}
+	}
+	acl := make(ACL, len(stored))
+	copy(acl, stored)
+	for i, e := range acl {
+		switch e.Tag {
+		case ACL_USER_OBJ:
+			acl[i].Perm = mode >> 6 & 07
+		case ACL_MASK:
+			acl[i].Perm = mode >> 3 & 07
+		case ACL_OTHER:
+			acl[i].Perm = mode & 07
+		}
+	}
+	return acl
+}
+
+func (fs *FileSystem) setAccessACL(node *inode.Inode, acl ACL) {
+	acl = acl.sorted()
+	user, _ := acl.find(ACL_USER_OBJ)
+	group, _ := acl.find(ACL_GROUP_OBJ)
+	other, _ := acl.find(ACL_OTHER)
+	if mask, ok := acl.find(ACL_MASK); ok {
+		group = mask
+	}
+	node.Mode = node.Mode&^os.ModePerm | user.Perm<<6 | group.Perm<<3 | other.Perm
+
+	if acl.extended() {
+		fs.acls[node.Ino] = acl
+		return
+	}
+	delete(fs.acls, node.Ino)
+}
+
+// createMode returns the permission bits for a new file created in parent with
+// the requested perm. As on Linux, the umask is not applied when the parent
+// has a default ACL.
+func (fs *FileSystem) createMode(parent *inode.Inode, perm os.FileMode) os.FileMode {
+	if _, ok := fs.defaultACLs[parent.Ino]; ok {
+		return perm
+	}
+	return fs.Umask & perm
+}
+
+// inheritACL applies the default ACL of parent, if any, to the newly created
+// child. The permissions of the inherited entries are limited by the mode the
+// child was created with.
+func (fs *FileSystem) inheritACL(parent, child *inode.Inode) {
+	def, ok := fs.defaultACLs[parent.Ino]
+	if !ok {
+		return
+	}
+	mode := child.Mode.Perm()
+	acl := make(ACL, len(def))
+	copy(acl, def)
+	_, hasMask := acl.find(ACL_MASK)
+	for i, e := range acl {
+		switch {
+		case e.Tag == ACL_USER_OBJ:
+			acl[i].Perm &= mode >> 6 & 07
+		case e.Tag == ACL_MASK, e.Tag == ACL_GROUP_OBJ && !hasMask:
+			acl[i].Perm &= mode >> 3 & 07
+		case e.Tag == ACL_OTHER:
+			acl[i].Perm &= mode & 07
+		}
+	}
+	fs.setAccessACL(child, acl)
+	if child.IsDir() {
+		fs.defaultACLs[child.Ino] = def
+	}
+}
+
+// owns reports whether the credentials of the FileSystem may change the mode
+// and ACLs of node, that is whether they are root or own node.
+func (fs *FileSystem) owns(node *inode.Inode) bool {
+	return fs.Uid == 0 || uint32(fs.Uid) == node.Uid
+}
+
+// mayChown reports whether the credentials of the FileSystem may set the owner
+// and group of node to uid and gid, where -1 leaves an id unchanged. Only root
+// may change the owner; the owner may change the group to one of its groups.
+func (fs *FileSystem) mayChown(node *inode.Inode, uid, gid int) bool {
+	switch {
+	case fs.Uid == 0:
+		return true
+	case !fs.owns(node):
+		return false
+	case uid != -1 && uint32(uid) != node.Uid:
+		return false
+	}
+	return gid == -1 || uint32(gid) == node.Gid || fs.inGroup(gid)
+}
+
+// access reports whether the credentials of the FileSystem grant the
+// requested permissions (a combination of read 04, write 02 and execute 01)
+// on node, following the POSIX ACL access check algorithm.
+func (fs *FileSystem) access(node *inode.Inode, want os.FileMode) bool {
+	if fs.Uid == 0 {
+		return true
+	}
+	acl := fs.accessACL(node)
+	mask := os.FileMode(07)
+	if e, ok := acl.find(ACL_MASK); ok {
+		mask = e.Perm
+	}
+
+	if uint32(fs.Uid) == node.Uid {
+		e, _ := acl.find(ACL_USER_OBJ)
+		return e.Perm&want == want
+	}
+	for _, e := range acl {
+		if e.Tag == ACL_USER && e.Qualifier == fs.Uid {
+			return e.Perm&mask&want == want
+		}
+	}
+
+	matched := false
+	for _, e := range acl {
+		var gid int
+		switch e.Tag {
+		case ACL_GROUP_OBJ:
+			gid = int(node.Gid)
+		case ACL_GROUP:
+			gid = e.Qualifier
+		default:
+			continue
+		}
+		if !fs.inGroup(gid) {
+			continue
+		}
+		matched = true
+		if e.Perm&mask&want == want {
+			return true
+		}
+	}
+	if matched {
+		return false
+	}
+
+	e, _ := acl.find(ACL_OTHER)
+	return e.Perm&want == want
+}
+
+// inGroup reports whether gid is the primary or a supplementary group of the
+// FileSystem credentials.
+func (fs *FileSystem) inGroup(gid int) bool {
+	if gid == fs.Gid {
+		return true
+	}
+	for _, g := range fs.Groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
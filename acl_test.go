@@ -0,0 +1,242 @@
+package memfs_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestParseACL(t *testing.T) {
+	text := `# file: data.txt
+# owner: 1000
+# group: 1000
+user::rw-
+user:1001:rwx	#effective:r--
+group::r--
+group:2000:rw-	#effective:r--
+mask::r--
+other::---
+`
+	acl, err := memfs.ParseACL(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acl) != 6 {
+		t.Fatalf("wrong entry count %d", len(acl))
+	}
+	want := "user::rw-\nuser:1001:rwx\ngroup::r--\ngroup:2000:rw-\nmask::r--\nother::---\n"
+	if acl.String() != want {
+		t.Errorf("wrong text:\n%s\nexpected:\n%s", acl, want)
+	}
+
+	acl, err = memfs.ParseACL("u::rw,g::r,o::-,u:1001:6,m::rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "user::rw-\nuser:1001:rw-\ngroup::r--\nmask::rw-\nother::---\n"
+	if acl.String() != want {
+		t.Errorf("wrong text:\n%s\nexpected:\n%s", acl, want)
+	}
+
+	access, def, err := memfs.ParseFacl("user::rwx\ngroup::r-x\nother::r-x\ndefault:user::rwx\ndefault:group::r-x\ndefault:other::---\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(access) != 3 || len(def) != 3 {
+		t.Errorf("wrong entry counts %d, %d", len(access), len(def))
+	}
+
+	for _, bad := range []string{"user:rw-", "bogus::rw-", "user:bob:rw-", "other:1:r--", "user::rwz"} {
+		if _, err := memfs.ParseACL(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestACL(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Chown("/", 1000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Uid, fs.Gid, fs.Groups = 1000, 1000, nil
+
+	f, err := fs.OpenFile("/data.txt", os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	acl, err := fs.GetACL("/data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "user::rw-\ngroup::r--\nother::---\n"; acl.String() != want {
+		t.Errorf("wrong minimal ACL:\n%s\nexpected:\n%s", acl, want)
+	}
+
+	acl, err = memfs.ParseACL("user::rw-,user:1001:rw-,group::r--,group:2000:r--,mask::rw-,other::---")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.SetACL("/data.txt", acl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat("/data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("mode not updated from mask: %s", info.Mode())
+	}
+
+	// A named user entry grants access limited by the mask.
+	fs.Uid, fs.Gid = 1001, 1001
+	f, err = fs.OpenFile("/data.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("named user denied: %v", err)
+	}
+	f.Close()
+
+	// Reducing the group class bits with chmod reduces the mask.
+	fs.Uid, fs.Gid = 1000, 1000
+	err = fs.Chmod("/data.txt", 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl, err = fs.GetACL("/data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "user::rw-\nuser:1001:rw-\ngroup::r--\ngroup:2000:r--\nmask::r--\nother::---\n"; acl.String() != want {
+		t.Errorf("wrong ACL after chmod:\n%s\nexpected:\n%s", acl, want)
+	}
+	fs.Uid, fs.Gid = 1001, 1001
+	_, err = fs.OpenFile("/data.txt", os.O_RDWR, 0)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+
+	// Supplementary groups are matched against named group entries.
+	fs.Uid, fs.Gid, fs.Groups = 1002, 1002, []int{2000}
+	f, err = fs.Open("/data.txt")
+	if err != nil {
+		t.Fatalf("named group denied: %v", err)
+	}
+	f.Close()
+
+	// Everyone else falls through to the other entry.
+	fs.Uid, fs.Gid, fs.Groups = 1003, 1003, nil
+	_, err = fs.Open("/data.txt")
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+
+	// Invalid ACLs are rejected.
+	fs.Uid, fs.Gid = 1000, 1000
+	acl, err = memfs.ParseACL("user::rw-,user:1001:rw-,group::r--,other::---")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.SetACL("/data.txt", acl)
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("expected EINVAL for ACL without mask, got %v", err)
+	}
+}
+
+func TestDefaultACL(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Chown("/", 1000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Uid, fs.Gid, fs.Groups = 1000, 1000, nil
+
+	err = fs.Mkdir("/shared", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := memfs.ParseACL("user::rwx,user:1001:rwx,group::r-x,mask::rwx,other::---")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.SetDefaultACL("/shared", def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.OpenFile("/shared/file.txt", os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	acl, err := fs.GetACL("/shared/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "user::rw-\nuser:1001:rwx\ngroup::r-x\nmask::rw-\nother::---\n"; acl.String() != want {
+		t.Errorf("wrong inherited ACL:\n%s\nexpected:\n%s", acl, want)
+	}
+
+	err = fs.Mkdir("/shared/sub", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subdef, err := fs.GetDefaultACL("/shared/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subdef.String() != def.String() {
+		t.Errorf("default ACL not inherited by directory:\n%s", subdef)
+	}
+
+	f, err = fs.Create("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	err = fs.SetDefaultACL("/file.txt", def)
+	if !errors.Is(err, syscall.EACCES) {
+		t.Errorf("expected EACCES setting default ACL on a file, got %v", err)
+	}
+}
+
+func TestACLNotOwner(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Uid, fs.Gid = 0, 0
+	if err := fs.Mkdir("/dir", 0777); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := memfs.ParseACL("user::rwx,user:1000:rwx,group::rwx,mask::rwx,other::rwx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := fs.View()
+	user.Uid, user.Gid, user.Groups = 1000, 1000, nil
+	if err := user.SetACL("/dir", acl); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("setacl as non-owner: expected EPERM, got %v", err)
+	}
+	if err := user.SetDefaultACL("/dir", acl); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("setting the default ACL as non-owner: expected EPERM, got %v", err)
+	}
+	if got, _ := fs.GetACL("/dir"); len(got) != 3 {
+		t.Errorf("ACL changed by non-owner:\n%s", got)
+	}
+	if got, _ := fs.GetDefaultACL("/dir"); len(got) != 0 {
+		t.Errorf("default ACL set by non-owner:\n%s", got)
+	}
+}
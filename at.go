@@ -0,0 +1,211 @@
+package memfs
+
+import (
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"syscall"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/inode"
+)
+
+// Flags for the *At methods of File. The values match Linux.
+const (
+	// AT_SYMLINK_NOFOLLOW makes StatAt describe a final symbolic link
+	// instead of its target.
+	AT_SYMLINK_NOFOLLOW = 0x100
+
+	// AT_REMOVEDIR makes UnlinkAt remove an empty directory instead of a
+	// file.
+	AT_REMOVEDIR = 0x200
+)
+
+// Flags for OpenAt restricting how a path is resolved, as in the resolve field
+// of openat2(2). The values match Linux.
+const (
+	// RESOLVE_NO_SYMLINKS fails with ELOOP if any element of the path is a
+	// symbolic link.
+	RESOLVE_NO_SYMLINKS = 0x04
+
+	// RESOLVE_BENEATH fails with EXDEV if the path, or any symbolic link
+	// followed while resolving it, is absolute or leaves the starting
+	// directory through "..".
+	RESOLVE_BENEATH = 0x08
+)
+
+// at returns the directory inode the *At methods resolve relative paths
+// against. f keeps referring to the same directory when it is renamed or
+// moved, so a path resolved through f cannot be redirected by changes to the
+// paths above it. Absolute paths are resolved against the root, as in
+// openat(2).
+func (f *File) at() (*inode.Inode, error) {
+	if f.node == nil {
+		return nil, syscall.EBADF
+	}
+	if !f.node.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	return f.node, nil
+}
+
+// atName returns the name of a file opened relative to f.
+func (f *File) atName(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(f.name, name)
+}
+
+// OpenAt opens name relative to f with the given flag and perm as OpenFile
+// does. resolve is zero or a combination of RESOLVE_* flags.
+func (f *File) OpenAt(name string, flag int, perm os.FileMode, resolve int) (absfs.File, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	dir, err := f.at()
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: name, Err: err}
+	}
+	if resolve&^(RESOLVE_NO_SYMLINKS|RESOLVE_BENEATH) != 0 {
+		return nil, &os.PathError{Op: "openat", Path: name, Err: syscall.EINVAL}
+	}
+	file, err := f.fs.openFile(dir, name, flag, perm, resolve)
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: name, Err: err}
+	}
+	if file, ok := file.(*File); ok {
+		file.name = f.atName(name)
+	}
+	return file, nil
+}
+
+// MkdirAt creates the directory name relative to f.
+func (f *File) MkdirAt(name string, perm os.FileMode) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	dir, err := f.at()
+	if err == nil {
+		err = f.fs.mkdir(dir, name, perm)
+	}
+	if err != nil {
+		return &os.PathError{Op: "mkdirat", Path: name, Err: err}
+	}
+	return nil
+}
+
+// UnlinkAt removes name relative to f. flags is 0 to remove a file, failing
+// with EISDIR for a directory, or AT_REMOVEDIR to remove an empty directory,
+// failing with ENOTDIR for anything else.
+func (f *File) UnlinkAt(name string, flags int) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	err := f.unlinkAt(name, flags)
+	if err != nil {
+		return &os.PathError{Op: "unlinkat", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) unlinkAt(name string, flags int) error {
+	dir, err := f.at()
+	if err != nil {
+		return err
+	}
+	if flags&^AT_REMOVEDIR != 0 {
+		return syscall.EINVAL
+	}
+	loc, err := f.fs.walkFrom(dir, name, false, 0)
+	if err != nil {
+		return err
+	}
+	var isDir bool
+	switch {
+	case loc.mount != nil:
+		info, err := loc.mount.lstat(loc.rest)
+		if err != nil {
+			return unwrap(err)
+		}
+		isDir = info.IsDir()
+	case loc.node == nil:
+		return syscall.ENOENT
+	default:
+		isDir = loc.node.IsDir()
+	}
+	removedir := flags&AT_REMOVEDIR != 0
+	if removedir && !isDir {
+		return syscall.ENOTDIR
+	}
+	if !removedir && isDir {
+		return syscall.EISDIR
+	}
+	return f.fs.remove(loc)
+}
+
+// RenameAt renames oldname, relative to f, to newname, relative to newdir,
+// with the semantics of Rename.
+func (f *File) RenameAt(oldname string, newdir *File, newname string) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	err := f.renameAt(oldname, newdir, newname)
+	if err != nil {
+		return &os.LinkError{Op: "renameat", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (f *File) renameAt(oldname string, newdir *File, newname string) error {
+	olddir, err := f.at()
+	if err != nil {
+		return err
+	}
+	if newdir == nil {
+		return syscall.EBADF
+	}
+	dir, err := newdir.at()
+	if err != nil {
+		return err
+	}
+	if newdir.fs.tree != f.fs.tree {
+		return syscall.EXDEV
+	}
+	return f.fs.rename(olddir, oldname, dir, newname, 0)
+}
+
+// StatAt returns a FileInfo describing name relative to f. flags is 0 or
+// AT_SYMLINK_NOFOLLOW to describe a final symbolic link itself.
+func (f *File) StatAt(name string, flags int) (os.FileInfo, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	info, err := f.statAt(name, flags)
+	if err != nil {
+		return nil, &os.PathError{Op: "fstatat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (f *File) statAt(name string, flags int) (os.FileInfo, error) {
+	dir, err := f.at()
+	if err != nil {
+		return nil, err
+	}
+	if flags&^AT_SYMLINK_NOFOLLOW != 0 {
+		return nil, syscall.EINVAL
+	}
+	follow := flags&AT_SYMLINK_NOFOLLOW == 0
+	loc, err := f.fs.walkFrom(dir, name, follow, 0)
+	if err != nil {
+		return nil, err
+	}
+	if loc.mount != nil {
+		var info os.FileInfo
+		if follow {
+			info, err = loc.mount.fs.Stat(loc.rest)
+		} else {
+			info, err = loc.mount.lstat(loc.rest)
+		}
+		return info, unwrap(err)
+	}
+	if loc.node == nil {
+		return nil, syscall.ENOENT
+	}
+	return f.fs.fileinfo(filepath.Base(name), loc.node), nil
+}
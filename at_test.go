@@ -0,0 +1,219 @@
+package memfs_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func openDir(t *testing.T, fs *memfs.FileSystem, name string) *memfs.File {
+	t.Helper()
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.(*memfs.File)
+}
+
+func TestAt(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/a/b", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := openDir(t, fs, "/a/b")
+	defer dir.Close()
+
+	// The handle follows the directory when its path changes.
+	err = fs.Rename("/a", "/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/a", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := dir.OpenAt("file.txt", os.O_CREATE|os.O_WRONLY, 0644, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("data"))
+	f.Close()
+	if _, err := fs.Stat("/x/b/file.txt"); err != nil {
+		t.Errorf("file not created in the renamed directory: %v", err)
+	}
+
+	err = dir.MkdirAt("sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fs.Stat("/x/b/sub"); err != nil || !info.IsDir() {
+		t.Errorf("directory not created in the renamed directory: %v", err)
+	}
+
+	info, err := dir.StatAt("file.txt", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 4 {
+		t.Errorf("wrong size %d", info.Size())
+	}
+
+	err = fs.Symlink("file.txt", "/x/b/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = dir.StatAt("link", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() {
+		t.Errorf("StatAt did not follow the link: %s", info.Mode())
+	}
+	info, err = dir.StatAt("link", memfs.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("StatAt followed the link: %s", info.Mode())
+	}
+
+	err = dir.UnlinkAt("sub", 0)
+	if !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("expected EISDIR, got %v", err)
+	}
+	err = dir.UnlinkAt("file.txt", memfs.AT_REMOVEDIR)
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+	err = dir.UnlinkAt("sub", memfs.AT_REMOVEDIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/x/b/sub"); !os.IsNotExist(err) {
+		t.Errorf("directory not removed: %v", err)
+	}
+
+	other := openDir(t, fs, "/a")
+	defer other.Close()
+	err = dir.RenameAt("file.txt", other, "moved.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a/moved.txt"); err != nil {
+		t.Errorf("file not moved: %v", err)
+	}
+	err = other.UnlinkAt("moved.txt", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// handles from views of the same tree may be combined, but not handles
+	// from different trees
+	view := openDir(t, fs.View(), "/x/b")
+	defer view.Close()
+	writeFile(t, fs, "/a/view.txt", "")
+	err = other.RenameAt("view.txt", view, "view.txt")
+	if err != nil {
+		t.Errorf("renameat across views: %v", err)
+	}
+	if _, err := fs.Stat("/x/b/view.txt"); err != nil {
+		t.Errorf("file not moved across views: %v", err)
+	}
+	foreignFS, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign := openDir(t, foreignFS, "/")
+	defer foreign.Close()
+	err = view.RenameAt("view.txt", foreign, "view.txt")
+	if !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("expected EXDEV, got %v", err)
+	}
+
+	notdir, err := fs.Create("/a/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notdir.Close()
+	_, err = notdir.(*memfs.File).OpenAt("x", os.O_RDONLY, 0, 0)
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+	closed := openDir(t, fs, "/a")
+	closed.Close()
+	err = closed.MkdirAt("x", 0755)
+	if !errors.Is(err, syscall.EBADF) {
+		t.Errorf("expected EBADF, got %v", err)
+	}
+}
+
+func TestResolveRestrictions(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/jail/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/jail/file", "/outside"} {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	links := map[string]string{
+		"/jail/rel":    "sub/../file",
+		"/jail/abs":    "/jail/file",
+		"/jail/escape": "../outside",
+	}
+	for name, target := range links {
+		err = fs.Symlink(target, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := openDir(t, fs, "/jail")
+	defer dir.Close()
+
+	tests := []struct {
+		name    string
+		resolve int
+		err     error
+	}{
+		{"file", memfs.RESOLVE_BENEATH, nil},
+		{"sub/../file", memfs.RESOLVE_BENEATH, nil},
+		{"rel", memfs.RESOLVE_BENEATH, nil},
+		{"../outside", 0, nil},
+		{"../outside", memfs.RESOLVE_BENEATH, syscall.EXDEV},
+		{"sub/../../outside", memfs.RESOLVE_BENEATH, syscall.EXDEV},
+		{"/jail/file", memfs.RESOLVE_BENEATH, syscall.EXDEV},
+		{"abs", 0, nil},
+		{"abs", memfs.RESOLVE_BENEATH, syscall.EXDEV},
+		{"escape", memfs.RESOLVE_BENEATH, syscall.EXDEV},
+		{"rel", memfs.RESOLVE_NO_SYMLINKS, syscall.ELOOP},
+		{"file", memfs.RESOLVE_NO_SYMLINKS, nil},
+		{"file", 0x1000, syscall.EINVAL},
+	}
+	for _, test := range tests {
+		f, err := dir.OpenAt(test.name, os.O_RDONLY, 0, test.resolve)
+		if test.err == nil && err != nil {
+			t.Errorf("%s %#x: %v", test.name, test.resolve, err)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s %#x: expected %v, got %v", test.name, test.resolve, test.err, err)
+		}
+		if err == nil {
+			f.Close()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package memfs
+
+import (
+	"errors"
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"time"
+)
+
+// Entry describes a file created by Build. Entries are created with Dir,
+// Regular, Symlink and Hardlink, and their owner and modification time may be
+// set with the Owner and Mtime methods.
+type Entry struct {
+	name    string
+	mode    os.FileMode // file type and permission bits.
+	content string
+	target  string // target of a symbolic link, or the linked file.
+	hard    bool   // whether the entry is a hard link to target.
+	entries []Entry
+
+	owner    bool
+	uid, gid int
+	mtime    time.Time
+}
+
+// Dir describes a directory with the given permissions containing entries. The
+// directory may already exist, in which case its permissions are changed.
+func Dir(name string, perm os.FileMode, entries ...Entry) Entry {
+	return Entry{name: name, mode: os.ModeDir | perm&chmodBits, entries: entries}
+}
+
+// Regular describes a regular file with the given content and permissions.
+func Regular(name, content string, perm os.FileMode) Entry {
+	return Entry{name: name, mode: perm & chmodBits, content: content}
+}
+
+// Symlink describes a symbolic link to target.
+func Symlink(name, target string) Entry {
+	return Entry{name: name, mode: os.ModeSymlink | 0777, target: target}
+}
+
+// Hardlink describes a hard link to the file target, a path resolved relative
+// to the working directory like the names of the entries passed to Build. Hard
+// links are created after all other entries, so target may be described by a
+// later entry.
+func Hardlink(name, target string) Entry {
+	return Entry{name: name, target: target, hard: true}
+}
+
+// Owner returns e with its owner set to uid and gid.
+func (e Entry) Owner(uid, gid int) Entry {
+	e.owner, e.uid, e.gid = true, uid, gid
+	return e
+}
+
+// Mtime returns e with its access and modification times set to t.
+func (e Entry) Mtime(t time.Time) Entry {
+	e.mtime = t
+	return e
+}
+
+// Build creates the files described by entries in fs, with names relative to
+// its working directory. Permissions are set exactly, without applying the
+// umask. Times and the permissions of directories are set after all files are
+// created, so that creating the contents of a directory neither changes its
+// modification time nor needs its final permissions to allow it. Build creates
+// as much as it can and returns all errors joined with errors.Join. The
+// contents of a directory that cannot be created are skipped.
+func Build(fs *FileSystem, entries ...Entry) error {
+	b := &builder{fs: fs}
+	for _, e := range entries {
+		b.create("", e)
+	}
+	for _, l := range b.links {
+		if b.check(fs.Link(l.target, l.path)) {
+			b.finish(l.path, l.Entry)
+		}
+	}
+	for i := len(b.times) - 1; i >= 0; i-- {
+		t := b.times[i]
+		b.check(fs.Lchtimes(t.path, t.mtime, t.mtime))
+	}
+	for i := len(b.dirs) - 1; i >= 0; i-- {
+		d := b.dirs[i]
+		b.check(fs.Chmod(d.path, d.mode))
+	}
+	return errors.Join(b.errs...)
+}
+
+// builder holds the state of Build.
+type builder struct {
+	fs    *FileSystem
+	links []builtEntry // hard links, created last.
+	times []builtEntry // entries with a modification time, in creation order.
+	dirs  []builtEntry // directories, in creation order.
+	errs  []error
+}
+
+// builtEntry is an entry with its path.
+type builtEntry struct {
+	path string
+	Entry
+}
+
+func (b *builder) check(err error) bool {
+	if err != nil {
+		b.errs = append(b.errs, err)
+		return false
+	}
+	return true
+}
+
+// create creates the entry e in the directory dir, and its contents.
+func (b *builder) create(dir string, e Entry) {
+	name := e.name
+	if dir != "" {
+		name = filepath.Join(dir, e.name)
+	}
+	fs := b.fs
+	switch {
+	case e.hard:
+		b.links = append(b.links, builtEntry{name, e})
+		return
+	case e.mode.IsDir():
+		// the directory stays accessible to its owner until its contents
+		// are created, and gets its permissions at the end
+		err := fs.Mkdir(name, 0700)
+		if os.IsExist(err) {
+			info, serr := fs.Lstat(name)
+			if serr == nil && info.IsDir() {
+				err = nil
+			}
+		}
+		if !b.check(err) || !b.check(fs.Chmod(name, 0700)) {
+			return
+		}
+		b.dirs = append(b.dirs, builtEntry{name, e})
+	case e.mode&os.ModeSymlink != 0:
+		if !b.check(fs.Symlink(e.target, name)) {
+			return
+		}
+	default:
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, e.mode)
+		if !b.check(err) {
+			return
+		}
+		_, err = f.Write([]byte(e.content))
+		b.check(err)
+		b.check(f.Close())
+		if !b.check(fs.Chmod(name, e.mode)) {
+			return
+		}
+	}
+	b.finish(name, e)
+	for _, child := range e.entries {
+		b.create(name, child)
+	}
+}
+
+// finish sets the owner of the created entry e and records its time.
+func (b *builder) finish(name string, e Entry) {
+	if e.owner {
+		b.check(b.fs.Lchown(name, e.uid, e.gid))
+	}
+	if !e.mtime.IsZero() {
+		b.times = append(b.times, builtEntry{name, e})
+	}
+}
@@ -0,0 +1,194 @@
+package memfs_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestBuild(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Umask = 0700
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	err = memfs.Build(fs,
+		memfs.Dir("a", 0755,
+			memfs.Regular("b.txt", "hi", 0644).Owner(1000, 100),
+			memfs.Symlink("c", "b.txt"),
+			memfs.Hardlink("d", "/a/b.txt"),
+			memfs.Dir("e", 0700),
+		).Mtime(mtime),
+		memfs.Regular("f", "", 0600).Mtime(mtime),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, fs, "/a/c"); s != "hi" {
+		t.Errorf("wrong content through link %q", s)
+	}
+	modes := map[string]os.FileMode{
+		"/a":       os.ModeDir | 0755,
+		"/a/b.txt": 0644,
+		"/a/c":     os.ModeSymlink | 0777,
+		"/a/d":     0644,
+		"/a/e":     os.ModeDir | 0700,
+		"/f":       0600,
+	}
+	for name, mode := range modes {
+		info, err := fs.Lstat(name)
+		if err != nil {
+			t.Errorf("lstat %s: %v", name, err)
+			continue
+		}
+		if info.Mode() != mode {
+			t.Errorf("%s: wrong mode %s, want %s", name, info.Mode(), mode)
+		}
+	}
+
+	info, err := fs.Stat("/a/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := memfs.StatOf(info)
+	if st.Nlink != 2 || st.Uid != 1000 || st.Gid != 100 {
+		t.Errorf("hard link: nlink %d, owner %d:%d", st.Nlink, st.Uid, st.Gid)
+	}
+	for _, name := range []string{"/a", "/f"} {
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s: wrong mtime %s", name, info.ModTime())
+		}
+	}
+
+	// an existing directory is reused
+	err = memfs.Build(fs, memfs.Dir("a", 0750, memfs.Regular("g", "", 0644)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = fs.Stat("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != os.ModeDir|0750 {
+		t.Errorf("wrong mode %s", info.Mode())
+	}
+}
+
+func TestBuildReadOnly(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chown("/", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	fs.Uid, fs.Gid = 1000, 1000
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// directories are filled before they lose their write permission
+	err = memfs.Build(fs,
+		memfs.Dir("ro", 0555, memfs.Regular("f", "x", 0444)),
+		memfs.Dir("locked", 0, memfs.Dir("sub", 0500, memfs.Regular("g", "", 0400).Mtime(mtime))),
+		memfs.Hardlink("locked/sub/h", "ro/f"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := fs.View()
+	root.Uid, root.Gid = 0, 0
+	modes := map[string]os.FileMode{
+		"/ro":           os.ModeDir | 0555,
+		"/ro/f":         0444,
+		"/locked":       os.ModeDir,
+		"/locked/sub":   os.ModeDir | 0500,
+		"/locked/sub/g": 0400,
+		"/locked/sub/h": 0444,
+	}
+	for name, mode := range modes {
+		info, err := root.Lstat(name)
+		if err != nil {
+			t.Errorf("lstat %s: %v", name, err)
+			continue
+		}
+		if info.Mode() != mode {
+			t.Errorf("%s: wrong mode %s, want %s", name, info.Mode(), mode)
+		}
+	}
+	if info, err := root.Lstat("/locked/sub/g"); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("wrong mtime: %v", err)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/file", "")
+
+	err = memfs.Build(fs,
+		memfs.Regular("file", "", 0644),
+		memfs.Dir("file", 0755, memfs.Regular("skipped", "", 0644)),
+		memfs.Hardlink("link", "missing").Owner(1000, 100).Mtime(time.Unix(0, 0)),
+		memfs.Regular("ok", "", 0644),
+	)
+	if !errors.Is(err, syscall.EEXIST) || !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("expected EEXIST and ENOENT, got %v", err)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 3 {
+		t.Errorf("expected 3 errors, got %d: %v", n, err)
+	}
+	if _, err := fs.Stat("/ok"); err != nil {
+		t.Errorf("file after errors not created: %v", err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/file", "content")
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Link("/file", "/dir/link"); err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/dir/link"); s != "content" {
+		t.Errorf("wrong content %q", s)
+	}
+	if err := fs.Remove("/file"); err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/dir/link"); s != "content" {
+		t.Errorf("wrong content after removing the original %q", s)
+	}
+
+	for _, tc := range []struct {
+		oldname, newname string
+		errno            syscall.Errno
+	}{
+		{"/dir", "/dir2", syscall.EPERM},
+		{"/dir/link", "/dir", syscall.EEXIST},
+		{"/missing", "/new", syscall.ENOENT},
+	} {
+		err := fs.Link(tc.oldname, tc.newname)
+		var lerr *os.LinkError
+		if !errors.As(err, &lerr) || lerr.Op != "link" || !errors.Is(err, tc.errno) {
+			t.Errorf("link %s %s: expected %v, got %v", tc.oldname, tc.newname, tc.errno, err)
+		}
+	}
+}
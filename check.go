@@ -0,0 +1,269 @@
+package memfs
+
+import (
+	"fmt"
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"sort"
+	"strings"
+
+	"github.com/absfs/inode"
+)
+
+// A Problem is an inconsistency found by Check.
+type Problem struct {
+	Ino  uint64 // inode with the problem, or 0 for the tree as a whole.
+	Path string // first path found to the inode, or "" if it is unreachable.
+	Msg  string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Path != "":
+		return fmt.Sprintf("%s (inode %d): %s", p.Path, p.Ino, p.Msg)
+	case p.Ino != 0:
+		return fmt.Sprintf("inode %d: %s", p.Ino, p.Msg)
+	}
+	return p.Msg
+}
+
+// A Report is the result of Check: the number of inodes reachable from the
+// root of the tree, by type, and the problems found.
+type Report struct {
+	Inodes   int
+	Dirs     int
+	Files    int
+	Symlinks int
+	Problems []Problem
+}
+
+// OK reports whether no problems were found.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// String returns a summary line followed by one line per problem.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d inodes: %d directories, %d files, %d symbolic links; %d problems",
+		r.Inodes, r.Dirs, r.Files, r.Symlinks, len(r.Problems))
+	for _, p := range r.Problems {
+		b.WriteString("\n")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// Check walks every inode reachable from the root of the tree of fs, which is
+// above the root of a Sub, and verifies the invariants the FileSystem keeps:
+//
+//   - the "." and ".." entries of every directory refer to the directory and
+//     its parent, and no directory is linked more than once or is its own
+//     ancestor;
+//   - entries are sorted and have unique, non-empty names without a slash;
+//   - link counts match the number of entries referring to each inode;
+//   - sizes match the stored contents of files and the targets of symbolic
+//     links, and every symbolic link has a target;
+//   - inode numbers are unique and allocated, and no contents, targets,
+//     extended attributes, ACLs or birth times are kept for inodes that are
+//     neither linked nor open.
+//
+// Check does not descend into mounted filesystems. It is meant for tests and
+// fuzzing.
+func (fs *FileSystem) Check() *Report {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	c := &checker{
+		fs:     fs,
+		report: new(Report),
+		nodes:  make(map[uint64]*inode.Inode),
+		paths:  make(map[*inode.Inode]string),
+		refs:   make(map[*inode.Inode]uint64),
+		active: make(map[*inode.Inode]bool),
+	}
+	c.visit(fs.top, "/")
+	c.dir(fs.top, fs.top, "/")
+	c.finish()
+	return c.report
+}
+
+// checker holds the state of Check.
+type checker struct {
+	fs     *FileSystem
+	report *Report
+	order  []*inode.Inode          // reachable inodes in the order found.
+	nodes  map[uint64]*inode.Inode // reachable inodes by number.
+	paths  map[*inode.Inode]string // first path found to each inode.
+	refs   map[*inode.Inode]uint64 // entries referring to each inode.
+	active map[*inode.Inode]bool   // directories being walked.
+}
+
+func (c *checker) problem(node *inode.Inode, format string, args ...interface{}) {
+	p := Problem{Msg: fmt.Sprintf(format, args...)}
+	if node != nil {
+		p.Ino, p.Path = node.Ino, c.paths[node]
+	}
+	c.report.Problems = append(c.report.Problems, p)
+}
+
+// visit checks node, reached as p, the first time it is reached.
+func (c *checker) visit(node *inode.Inode, p string) {
+	if _, ok := c.paths[node]; ok {
+		return
+	}
+	c.paths[node] = p
+	c.order = append(c.order, node)
+	c.report.Inodes++
+
+	if other, ok := c.nodes[node.Ino]; ok {
+		c.problem(node, "inode number shared with %s", c.paths[other])
+	} else {
+		c.nodes[node.Ino] = node
+	}
+	if node.Ino == 0 || node.Ino >= uint64(len(c.fs.data)) {
+		c.problem(node, "inode number not allocated")
+		return
+	}
+	data := c.fs.data[int(node.Ino)]
+	target, isLink := c.fs.symlinks[node.Ino]
+	switch {
+	case node.IsDir():
+		c.report.Dirs++
+		if len(data) != 0 {
+			c.problem(node, "directory has %d bytes of contents", len(data))
+		}
+		if node.Size != 0 {
+			c.problem(node, "directory has size %d", node.Size)
+		}
+	case node.Mode&os.ModeSymlink != 0:
+		c.report.Symlinks++
+		if !isLink {
+			c.problem(node, "symbolic link has no target")
+		} else if node.Size != int64(len(target)) {
+			c.problem(node, "size %d, but target %q", node.Size, target)
+		}
+	default:
+		c.report.Files++
+		if node.Size != int64(len(data)) {
+			c.problem(node, "size %d, but %d bytes of contents", node.Size, len(data))
+		}
+	}
+	if isLink && node.Mode&os.ModeSymlink == 0 {
+		c.problem(node, "not a symbolic link, but has target %q", target)
+	}
+}
+
+// dir checks the entries of the directory dir, reached as p from parent, and
+// walks its subdirectories.
+func (c *checker) dir(dir, parent *inode.Inode, p string) {
+	c.active[dir] = true
+	defer delete(c.active, dir)
+
+	var dot, dotdot *inode.Inode
+	for i, e := range dir.Dir {
+		c.refs[e.Inode]++
+		switch {
+		case i > 0 && dir.Dir[i-1].Name >= e.Name:
+			c.problem(dir, "entry %q after %q", e.Name, dir.Dir[i-1].Name)
+		case e.Name == "" || strings.Contains(e.Name, "/"):
+			c.problem(dir, "invalid entry name %q", e.Name)
+		}
+		switch e.Name {
+		case ".":
+			dot = e.Inode
+		case "..":
+			dotdot = e.Inode
+		}
+	}
+	switch {
+	case dot == nil:
+		c.problem(dir, "no . entry")
+	case dot != dir:
+		c.problem(dir, ". is inode %d", dot.Ino)
+	}
+	switch {
+	case dotdot == nil:
+		c.problem(dir, "no .. entry")
+	case dotdot != parent:
+		c.problem(dir, ".. is inode %d, but the parent is inode %d", dotdot.Ino, parent.Ino)
+	}
+
+	for _, e := range dir.Dir {
+		if e.Name == "." || e.Name == ".." || e.Name == "" {
+			continue
+		}
+		child, name := e.Inode, filepath.Join(p, e.Name)
+		if child.IsDir() && c.paths[child] != "" {
+			if c.active[child] {
+				c.problem(child, "directory is its own ancestor at %s", name)
+			} else {
+				c.problem(child, "directory also linked as %s", name)
+			}
+			continue
+		}
+		c.visit(child, name)
+		if child.IsDir() {
+			c.dir(child, dir, name)
+		}
+	}
+}
+
+// finish checks the link counts of the reachable inodes and looks for data
+// kept for inodes that are no longer live.
+func (c *checker) finish() {
+	for _, node := range c.order {
+		if node.Nlink != c.refs[node] {
+			c.problem(node, "link count %d, but %d entries", node.Nlink, c.refs[node])
+		}
+	}
+	if n := uint64(len(c.fs.data)) - 1; n != uint64(*c.fs.ino) {
+		c.problem(nil, "%d data slots for %d inodes", n, uint64(*c.fs.ino))
+	}
+	for ino := 1; ino < len(c.fs.data); ino++ {
+		if !c.live(uint64(ino)) && len(c.fs.data[ino]) > 0 {
+			c.orphan(uint64(ino), "data slot with %d bytes", len(c.fs.data[ino]))
+		}
+	}
+	for _, ino := range orphans(c, c.fs.symlinks) {
+		c.orphan(ino, "symbolic link target %q", c.fs.symlinks[ino])
+	}
+	for _, ino := range orphans(c, c.fs.xattrs) {
+		c.orphan(ino, "extended attributes")
+	}
+	for _, ino := range orphans(c, c.fs.btimes) {
+		c.orphan(ino, "birth time")
+	}
+	for _, ino := range orphans(c, c.fs.acls) {
+		c.orphan(ino, "ACL")
+	}
+	for _, ino := range orphans(c, c.fs.defaultACLs) {
+		c.orphan(ino, "default ACL")
+	}
+}
+
+// live reports whether the inode ino is reachable or open.
+func (c *checker) live(ino uint64) bool {
+	_, ok := c.nodes[ino]
+	return ok || c.fs.open[ino] > 0
+}
+
+// orphans returns the sorted inode numbers of the entries of m whose inodes are
+// no longer live according to c.
+func orphans[V any](c *checker, m map[uint64]V) []uint64 {
+	var inos []uint64
+	for ino := range m {
+		if !c.live(ino) {
+			inos = append(inos, ino)
+		}
+	}
+	sort.Slice(inos, func(i, j int) bool { return inos[i] < inos[j] })
+	return inos
+}
+
+// orphan reports data kept for the inode ino, which is no longer live.
+func (c *checker) orphan(ino uint64, format string, args ...interface{}) {
+	c.report.Problems = append(c.report.Problems, Problem{
+		Ino: ino,
+		Msg: "orphaned " + fmt.Sprintf(format, args...),
+	})
+}
@@ -0,0 +1,201 @@
+package memfs_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestCheck(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := fs.Check()
+	if !r.OK() || r.Inodes != 1 || r.Dirs != 1 {
+		t.Fatalf("empty file system: %v", r)
+	}
+
+	err = memfs.Build(fs,
+		memfs.Dir("a", 0755,
+			memfs.Regular("b.txt", "hi", 0644),
+			memfs.Symlink("c", "b.txt"),
+			memfs.Hardlink("d", "/a/b.txt"),
+			memfs.Dir("e", 0700, memfs.Regular("f", "content", 0644)),
+		),
+		memfs.Regular("g", "", 0600),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = fs.Check()
+	if !r.OK() {
+		t.Fatal(r)
+	}
+	if r.Inodes != 7 || r.Dirs != 3 || r.Files != 3 || r.Symlinks != 1 {
+		t.Errorf("wrong counts: %v", r)
+	}
+	if s := r.String(); s != "7 inodes: 3 directories, 3 files, 1 symbolic links; 0 problems" {
+		t.Errorf("wrong report %q", s)
+	}
+
+	// the whole tree is checked from a Sub, even after its root is removed
+	sub, err := fs.Sub("/a/e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/a/e", "/e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/e"); err != nil {
+		t.Fatal(err)
+	}
+	if r := sub.Check(); !r.OK() || r.Inodes != 5 {
+		t.Errorf("check from a sub: %v", r)
+	}
+}
+
+func TestCheckReleased(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/file", "content")
+	writeFile(t, fs, "/other", "replaced")
+	if err := fs.Symlink("file", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an open file keeps its contents after its last link is removed, but
+	// does not write them back
+	f, err := fs.OpenFile("/file", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []func() error{
+		func() error { return fs.Remove("/file") },
+		func() error { return fs.Rename("/link", "/other") },
+	} {
+		if err := op(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Write([]byte("more ")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, _ := f.Read(buf)
+	if s := string(buf[:n]); s != "more nt" {
+		t.Errorf("wrong content of the unlinked file %q", s)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := fs.Check(); !r.OK() {
+		t.Error(r)
+	} else if r.Files != 0 || r.Symlinks != 1 {
+		t.Errorf("wrong counts: %v", r)
+	}
+	if _, err := fs.Stat("/other"); !os.IsNotExist(err) {
+		t.Errorf("link to the removed file: %v", err)
+	}
+}
+
+func TestCreateInRemovedDir(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/file", "")
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, op := range map[string]func() error{
+		"mkdir":   func() error { return fs.Mkdir("sub", 0755) },
+		"symlink": func() error { return fs.Symlink("target", "..") },
+		"link":    func() error { return fs.Link("/file", "link") },
+		"rename":  func() error { return fs.Rename("/file", "file") },
+		"create": func() error {
+			_, err := fs.OpenFile("file", os.O_CREATE|os.O_RDWR, 0644)
+			return err
+		},
+	} {
+		if err := op(); !os.IsNotExist(err) {
+			t.Errorf("%s in a removed directory: %v", name, err)
+		}
+	}
+	if r := fs.Check(); !r.OK() || r.Inodes != 2 {
+		t.Error(r)
+	}
+}
+
+func TestCheckReleasedMetadata(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Uid, fs.Gid = 0, 0
+	acl, err := memfs.ParseACL("user::rw-,user:1000:rw-,group::r--,mask::rw-,other::---")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := memfs.ParseACL("user::rwx,group::r-x,other::---")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/file", "content")
+	writeFile(t, fs, "/open", "content")
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/file", "/open"} {
+		if err := fs.Setxattr(name, "user.test", []byte("value"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.SetACL(name, acl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.SetDefaultACL("/dir", def); err != nil {
+		t.Fatal(err)
+	}
+
+	// the metadata of an open file is kept until it is closed
+	f, err := fs.Open("/open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/file", "/open", "/dir"} {
+		if err := fs.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := fs.Check(); !r.OK() {
+		t.Error(r)
+	}
+	buf := make([]byte, 16)
+	if n, err := f.(*memfs.File).Getxattr("user.test", buf); err != nil || string(buf[:n]) != "value" {
+		t.Errorf("attribute of the open file: %q, %v", buf[:n], err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r := fs.Check(); !r.OK() || r.Inodes != 1 {
+		t.Error(r)
+	}
+}
@@ -0,0 +1,65 @@
+package memfs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := memfs.NewFakeClock(start)
+	fs, err := memfs.NewFSWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(start) {
+		t.Errorf("wrong root ModTime %s, expected %s", info.ModTime(), start)
+	}
+
+	err = fs.MkdirAll("/a/b", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/a/b/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	f.Write([]byte("hello"))
+	f.Close()
+
+	for name, want := range map[string]time.Time{
+		"/a":            start,
+		"/a/b":          start,
+		"/a/b/file.txt": start.Add(time.Minute),
+	} {
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(want) {
+			t.Errorf("%s: wrong ModTime %s, expected %s", name, info.ModTime(), want)
+		}
+	}
+
+	later := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock.Set(later)
+	err = fs.RemoveAll("/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = fs.Stat("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(later) {
+		t.Errorf("wrong ModTime %s, expected %s", info.ModTime(), later)
+	}
+}
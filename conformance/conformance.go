@@ -0,0 +1,648 @@
+// Package conformance checks that an absfs.SymlinkFileSystem behaves like the
+// operating system. It runs identical sequences of operations against the file
+// system under test and against osfs in a temporary directory, and compares
+// their results, the types, errnos and text of their errors, and the trees
+// they leave behind.
+//
+// Sequences are built from the operations of this package, taken from the
+// standard cases of Run, generated randomly by RunRandom, or decoded from
+// fuzzer input by Decode. Names passed to operations are slash separated and
+// relative to the root of the target, and symbolic link targets are passed
+// unchanged.
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+	"github.com/absfs/osfs"
+)
+
+// Target is a file system under test, with the absolute path of the empty
+// directory the operations run in.
+type Target struct {
+	FS   absfs.SymlinkFileSystem
+	Root string
+}
+
+// Factory returns a new Target for a test.
+type Factory func(t testing.TB) Target
+
+// Memfs is the Factory of memfs file systems, running in their root with the
+// credentials of the current process, which owns the root, so that permission
+// checks match those of the reference. The consistency of the file system is checked when the test
+// ends.
+func Memfs(t testing.TB) Target {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := fs.View()
+	user.ProcessCredentials()
+	if err := fs.Chown("/", user.Uid, user.Gid); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if r := fs.Check(); !r.OK() {
+			t.Errorf("memfs check: %v", r)
+		}
+	})
+	return Target{FS: user, Root: "/"}
+}
+
+// OS is the Factory of the reference file system, osfs running in
+// t.TempDir().
+func OS(t testing.TB) Target {
+	fs, err := osfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := Target{FS: &osFS{fs}, Root: t.TempDir()}
+	// restore the permissions of directories before the directory is removed
+	t.Cleanup(func() { tree(target) })
+	return target
+}
+
+// osFS is osfs with the system calls of symbolic links and renames made
+// directly. osfs resolves relative link targets against its working directory,
+// unlike symlink(2).
+type osFS struct {
+	*osfs.FileSystem
+}
+
+func (fs *osFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, fs.abs(newname))
+}
+
+// Rename renames with rename(2). os.Rename also refuses to replace a
+// directory, failing with EEXIST, which rename(2) and memfs allow if it is
+// empty.
+func (fs *osFS) Rename(oldpath, newpath string) error {
+	oldabs, newabs := fs.abs(oldpath), fs.abs(newpath)
+	err := syscall.Rename(oldabs, newabs)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldabs, New: newabs, Err: err}
+	}
+	return nil
+}
+
+// abs returns name relative to the working directory.
+func (fs *osFS) abs(name string) string {
+	if path.IsAbs(name) {
+		return name
+	}
+	wd, _ := fs.Getwd()
+	return path.Join(wd, name)
+}
+
+// Op is an operation applied to both file systems. It returns a description of
+// its results, which must be equal for the file systems to conform.
+type Op struct {
+	desc string
+	do   func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error)
+}
+
+// String describes the call made by op, for example Mkdir("a", 0755).
+func (op Op) String() string {
+	return op.desc
+}
+
+// Mkdir creates a directory.
+func Mkdir(name string, perm os.FileMode) Op {
+	return Op{fmt.Sprintf("Mkdir(%q, %#o)", name, perm), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.Mkdir(abs(name), perm)
+	}}
+}
+
+// MkdirAll creates a directory and its missing parents.
+func MkdirAll(name string, perm os.FileMode) Op {
+	return Op{fmt.Sprintf("MkdirAll(%q, %#o)", name, perm), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.MkdirAll(abs(name), perm)
+	}}
+}
+
+// WriteFile writes data to a file, creating or truncating it.
+func WriteFile(name, data string, perm os.FileMode) Op {
+	return Op{fmt.Sprintf("WriteFile(%q, %q, %#o)", name, data, perm), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return write(fs, abs(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm, data)
+	}}
+}
+
+// Append appends data to an existing file.
+func Append(name, data string) Op {
+	return Op{fmt.Sprintf("Append(%q, %q)", name, data), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return write(fs, abs(name), os.O_WRONLY|os.O_APPEND, 0, data)
+	}}
+}
+
+func write(fs absfs.SymlinkFileSystem, name string, flag int, perm os.FileMode, data string) (string, error) {
+	f, err := fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return "", err
+	}
+	n, err := f.Write([]byte(data))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return fmt.Sprintf("n=%d", n), err
+}
+
+// OpenFile opens a file with flag and closes it.
+func OpenFile(name string, flag int, perm os.FileMode) Op {
+	return Op{fmt.Sprintf("OpenFile(%q, %#x, %#o)", name, flag, perm), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		f, err := fs.OpenFile(abs(name), flag, perm)
+		if err != nil {
+			return "", err
+		}
+		return "", f.Close()
+	}}
+}
+
+// ReadFile reads a file.
+func ReadFile(name string) Op {
+	return Op{fmt.Sprintf("ReadFile(%q)", name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		f, err := fs.Open(abs(name))
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		return fmt.Sprintf("%q", data), err
+	}}
+}
+
+// ReadDir lists the names in a directory, sorted and without "." and "..".
+func ReadDir(name string) Op {
+	return Op{fmt.Sprintf("ReadDir(%q)", name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		f, err := fs.Open(abs(name))
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		var list []string
+		for _, name := range names {
+			if name != "." && name != ".." {
+				list = append(list, name)
+			}
+		}
+		sort.Strings(list)
+		return fmt.Sprint(list), err
+	}}
+}
+
+// Remove removes a file or empty directory.
+func Remove(name string) Op {
+	return Op{fmt.Sprintf("Remove(%q)", name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.Remove(abs(name))
+	}}
+}
+
+// RemoveAll removes a file or directory and its contents.
+func RemoveAll(name string) Op {
+	return Op{fmt.Sprintf("RemoveAll(%q)", name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.RemoveAll(abs(name))
+	}}
+}
+
+// Rename renames a file.
+func Rename(oldname, newname string) Op {
+	return Op{fmt.Sprintf("Rename(%q, %q)", oldname, newname), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.Rename(abs(oldname), abs(newname))
+	}}
+}
+
+// Symlink creates a symbolic link to target.
+func Symlink(target, name string) Op {
+	return Op{fmt.Sprintf("Symlink(%q, %q)", target, name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.Symlink(target, abs(name))
+	}}
+}
+
+// Readlink reads the target of a symbolic link.
+func Readlink(name string) Op {
+	return Op{fmt.Sprintf("Readlink(%q)", name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return fs.Readlink(abs(name))
+	}}
+}
+
+// Stat describes a file, following symbolic links.
+func Stat(name string) Op {
+	return Op{fmt.Sprintf("Stat(%q)", name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		info, err := fs.Stat(abs(name))
+		return describe(info), err
+	}}
+}
+
+// Lstat describes a file without following symbolic links.
+func Lstat(name string) Op {
+	return Op{fmt.Sprintf("Lstat(%q)", name), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		info, err := fs.Lstat(abs(name))
+		return describe(info), err
+	}}
+}
+
+// Chmod changes the mode of a file.
+func Chmod(name string, mode os.FileMode) Op {
+	return Op{fmt.Sprintf("Chmod(%q, %#o)", name, mode), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.Chmod(abs(name), mode)
+	}}
+}
+
+// Truncate changes the size of a file.
+func Truncate(name string, size int64) Op {
+	return Op{fmt.Sprintf("Truncate(%q, %d)", name, size), func(fs absfs.SymlinkFileSystem, abs func(string) string) (string, error) {
+		return "", fs.Truncate(abs(name), size)
+	}}
+}
+
+// describe returns the mode of info and, unless it is a directory, its size.
+// The sizes of directories differ between file systems.
+func describe(info os.FileInfo) string {
+	if info == nil {
+		return ""
+	}
+	if info.IsDir() {
+		return info.Mode().String()
+	}
+	return fmt.Sprintf("%s size=%d", info.Mode(), info.Size())
+}
+
+// Case is a named sequence of operations.
+type Case struct {
+	Name string
+	Ops  []Op
+}
+
+// Cases returns the standard cases run by Run, covering the common errors of
+// each operation.
+func Cases() []Case {
+	return []Case{
+		{"mkdir", []Op{
+			Mkdir("a", 0755),
+			Mkdir("a", 0755),
+			Mkdir("b/c", 0755),
+			WriteFile("f", "", 0644),
+			Mkdir("f/d", 0755),
+			MkdirAll("a/b/c", 0700),
+			MkdirAll("f/d", 0755),
+			MkdirAll("a/b/c", 0755),
+			Stat("a/b/c"),
+			Symlink("missing", "dangling"),
+			MkdirAll("dangling/x", 0755),
+		}},
+		{"files", []Op{
+			WriteFile("f", "hello\n", 0644),
+			ReadFile("f"),
+			Append("f", "world\n"),
+			ReadFile("f"),
+			WriteFile("f", "x", 0600),
+			Stat("f"),
+			Append("missing", "x"),
+			ReadFile("missing"),
+			ReadFile("f/x"),
+			OpenFile("f", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644),
+			Mkdir("d", 0755),
+			OpenFile("d", os.O_WRONLY, 0),
+			OpenFile("d", os.O_RDONLY, 0),
+			WriteFile("d", "x", 0644),
+			Truncate("f", 3),
+			ReadFile("f"),
+			Truncate("d", 0),
+			Truncate("missing", 0),
+		}},
+		{"readdir", []Op{
+			MkdirAll("d/sub", 0755),
+			WriteFile("d/b", "", 0644),
+			WriteFile("d/a", "", 0644),
+			ReadDir("d"),
+			ReadDir("d/sub"),
+			ReadDir("d/a"),
+			ReadDir("missing"),
+		}},
+		{"remove", []Op{
+			Remove("missing"),
+			MkdirAll("d/sub", 0755),
+			WriteFile("d/sub/f", "", 0644),
+			Remove("d"),
+			Remove("d/sub/f/x"),
+			RemoveAll("d/sub/f/x"),
+			RemoveAll("d/sub/f/x/y"),
+			Remove("d/sub/f"),
+			Remove("d/sub"),
+			RemoveAll("missing"),
+			RemoveAll("missing/x"),
+			MkdirAll("d/sub", 0755),
+			RemoveAll("d"),
+			ReadDir("."),
+		}},
+		{"rename", []Op{
+			WriteFile("f", "f", 0644),
+			WriteFile("g", "g", 0644),
+			MkdirAll("d/sub", 0755),
+			Mkdir("e", 0755),
+			Rename("f", "g"),
+			ReadFile("g"),
+			Rename("missing", "x"),
+			Rename("g", "missing/x"),
+			Rename("d", "d/sub/x"),
+			Rename("g", "d"),
+			Rename("d", "g"),
+			Rename("d", "e"),
+			Rename("e", "d/sub"),
+			Rename("d", "d"),
+			WriteFile("d/sub/f", "", 0644),
+			Rename("d/sub/f", "d"),
+			Rename("missing", "d/sub/f/x"),
+			Stat("e"),
+		}},
+		{"symlink", []Op{
+			WriteFile("f", "content", 0644),
+			Symlink("f", "link"),
+			Symlink("missing", "dangling"),
+			Symlink("loop", "loop"),
+			Symlink("f", "f"),
+			Readlink("link"),
+			Readlink("f"),
+			Readlink("missing"),
+			Lstat("link"),
+			Stat("link"),
+			Stat("dangling"),
+			Lstat("dangling"),
+			Stat("loop"),
+			ReadFile("link"),
+			ReadFile("loop"),
+			WriteFile("dangling", "created", 0644),
+			ReadFile("missing"),
+			Remove("link"),
+			Stat("f"),
+		}},
+		{"removeall", []Op{
+			MkdirAll("d/sub/x", 0755),
+			WriteFile("d/g", "", 0644),
+			Chmod("d/sub", 0500),
+			RemoveAll("d"),
+			Chmod("d/sub", 0300),
+			RemoveAll("d/sub"),
+			Chmod("d", 0600),
+			RemoveAll("d/sub"),
+			RemoveAll("d/g"),
+			Chmod("d", 0700),
+			Chmod("d/sub", 0700),
+			RemoveAll("d"),
+		}},
+		{"chmod", []Op{
+			WriteFile("f", "", 0644),
+			Chmod("f", 0600),
+			Stat("f"),
+			Chmod("missing", 0600),
+			Mkdir("d", 0755),
+			Chmod("d", 0700),
+			Stat("d"),
+		}},
+	}
+}
+
+// Run runs the standard cases of Cases against targets returned by newFS.
+func Run(t *testing.T, newFS Factory) {
+	for _, c := range Cases() {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			RunOps(t, newFS, c.Ops)
+		})
+	}
+}
+
+// RunRandom runs n random sequences of length operations, generated from
+// seed, against targets returned by newFS.
+func RunRandom(t *testing.T, newFS Factory, seed int64, n, length int) {
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		data := make([]byte, 4*length)
+		r.Read(data)
+		ops := Decode(data)
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			RunOps(t, newFS, ops)
+		})
+	}
+}
+
+// RunOps runs ops against a new reference file system and a target returned by
+// newFS. It reports the first operation whose results or errors differ and
+// stops, and otherwise compares the trees left behind.
+func RunOps(t testing.TB, newFS Factory, ops []Op) {
+	t.Helper()
+	want, got := OS(t), newFS(t)
+	for i, op := range ops {
+		wres, werr := op.do(want.FS, want.abs)
+		gres, gerr := op.do(got.FS, got.abs)
+		if msg := compare(want, got, wres, werr, gres, gerr); msg != "" {
+			t.Errorf("step %d: %s: %s", i, op, msg)
+			return
+		}
+	}
+
+	wtree, err := tree(want)
+	if err != nil {
+		t.Fatalf("reference: %v", err)
+	}
+	gtree, err := tree(got)
+	if err != nil {
+		t.Errorf("tree: %v", err)
+		return
+	}
+	if wtree != gtree {
+		t.Errorf("trees differ:\n%s", memfs.UnifiedDiff("tree", []byte(wtree), []byte(gtree)))
+	}
+}
+
+// abs returns the absolute path of the slash separated name relative to the
+// root of t.
+func (t Target) abs(name string) string {
+	return path.Join(t.Root, name)
+}
+
+// relative replaces the root of t in s with "/", so that paths in results and
+// errors of targets compare equal.
+func (t Target) relative(s string) string {
+	if t.Root == "/" {
+		return s
+	}
+	s = strings.ReplaceAll(s, t.Root+"/", "/")
+	return strings.ReplaceAll(s, t.Root, "/")
+}
+
+// errorClasses are the errors compared with errors.Is.
+var errorClasses = []error{
+	iofs.ErrInvalid,
+	iofs.ErrPermission,
+	iofs.ErrExist,
+	iofs.ErrNotExist,
+	iofs.ErrClosed,
+	io.EOF,
+}
+
+// compare returns a description of the differences between the results of an
+// operation, or "" if there are none.
+func compare(want, got Target, wres string, werr error, gres string, gerr error) string {
+	wres, gres = want.relative(wres), got.relative(gres)
+	switch {
+	case werr == nil && gerr == nil:
+		if wres != gres {
+			return fmt.Sprintf("got %s, want %s", gres, wres)
+		}
+		return ""
+	case werr == nil:
+		return fmt.Sprintf("got error %v, want %s", got.relative(gerr.Error()), wres)
+	case gerr == nil:
+		return fmt.Sprintf("got %s, want error %v", gres, want.relative(werr.Error()))
+	}
+
+	wtext, gtext := want.relative(werr.Error()), got.relative(gerr.Error())
+	if wtype, gtype := fmt.Sprintf("%T", werr), fmt.Sprintf("%T", gerr); wtype != gtype {
+		return fmt.Sprintf("got error %s of type %s, want %s", gtext, gtype, wtype)
+	}
+	var wno, gno syscall.Errno
+	errors.As(werr, &wno)
+	errors.As(gerr, &gno)
+	if wno != gno {
+		return fmt.Sprintf("got errno %d (%v), want %d (%v)", gno, gerr, wno, werr)
+	}
+	for _, class := range errorClasses {
+		if errors.Is(werr, class) != errors.Is(gerr, class) {
+			return fmt.Sprintf("errors.Is(%q, %v) is %t", gtext, class, errors.Is(gerr, class))
+		}
+	}
+	if wtext != gtext {
+		return fmt.Sprintf("got error %q, want %q", gtext, wtext)
+	}
+	return ""
+}
+
+// tree describes the files below the root of t, one per line, with their mode
+// and the content of regular files or the target of symbolic links. Once their
+// mode is described, directories are given all permissions for their owner, so
+// that directories the operations made inaccessible can be walked and removed.
+func tree(t Target) (string, error) {
+	var b strings.Builder
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		f, err := t.FS.Open(t.abs(rel))
+		if err != nil {
+			return err
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if name == "." || name == ".." {
+				continue
+			}
+			name = path.Join(rel, name)
+			info, err := t.FS.Lstat(t.abs(name))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "%s %s", name, info.Mode())
+			switch mode := info.Mode(); {
+			case mode.IsDir():
+				b.WriteString("\n")
+				if mode.Perm()&0700 != 0700 {
+					err = t.FS.Chmod(t.abs(name), mode.Perm()|0700)
+				}
+				if err == nil {
+					err = walk(name)
+				}
+			case mode&os.ModeSymlink != 0:
+				var target string
+				target, err = t.FS.Readlink(t.abs(name))
+				fmt.Fprintf(&b, " -> %s\n", target)
+			default:
+				var data []byte
+				f, err = t.FS.Open(t.abs(name))
+				if err == nil {
+					data, err = io.ReadAll(f)
+					f.Close()
+				}
+				fmt.Fprintf(&b, " %q\n", data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(".")
+	return b.String(), err
+}
+
+// The operands of decoded operations.
+var (
+	decodeNames = []string{"a", "b", "a/b", "a/c", "b/a", "a/b/c"}
+	decodeData  = []string{"", "x", "hello\n"}
+	decodePerms = []os.FileMode{0755, 0700, 0644, 0600}
+)
+
+// Decode decodes a sequence of operations from arbitrary bytes, such as fuzzer
+// input, four bytes per operation. The operations act on a few names so that
+// they often interact.
+func Decode(data []byte) []Op {
+	var ops []Op
+	for ; len(data) >= 4; data = data[4:] {
+		name := decodeNames[int(data[1])%len(decodeNames)]
+		other := decodeNames[int(data[2])%len(decodeNames)]
+		content := decodeData[int(data[3])%len(decodeData)]
+		perm := decodePerms[int(data[3])%len(decodePerms)]
+		var op Op
+		switch data[0] % 16 {
+		case 0:
+			op = Mkdir(name, perm|0700)
+		case 1:
+			op = MkdirAll(name, perm|0700)
+		case 2:
+			op = WriteFile(name, content, perm)
+		case 3:
+			op = Append(name, content)
+		case 4:
+			op = ReadFile(name)
+		case 5:
+			op = ReadDir(name)
+		case 6:
+			op = Remove(name)
+		case 7:
+			op = RemoveAll(name)
+		case 8:
+			op = Rename(name, other)
+		case 9:
+			op = Symlink(other, name)
+		case 10:
+			op = Readlink(name)
+		case 11:
+			op = Stat(name)
+		case 12:
+			op = Lstat(name)
+		case 13:
+			op = Chmod(name, perm)
+		case 14:
+			op = Truncate(name, int64(data[3]%8))
+		default:
+			op = OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
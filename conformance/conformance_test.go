@@ -0,0 +1,22 @@
+package conformance_test
+
+import (
+	"testing"
+
+	"github.com/absfs/memfs/conformance"
+)
+
+func TestMemfs(t *testing.T) {
+	conformance.Run(t, conformance.Memfs)
+}
+
+func TestMemfsRandom(t *testing.T) {
+	conformance.RunRandom(t, conformance.Memfs, 1, 200, 30)
+}
+
+func FuzzMemfs(f *testing.F) {
+	f.Add([]byte("\x00\x00\x00\x00\x02\x02\x00\x01\x08\x02\x01\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conformance.RunOps(t, conformance.Memfs, conformance.Decode(data))
+	})
+}
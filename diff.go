@@ -0,0 +1,574 @@
+package memfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"sort"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/absfs/absfs"
+)
+
+// DiffFields is a set of attributes in which two files differ.
+type DiffFields uint
+
+const (
+	DiffType    DiffFields = 1 << iota // file type, such as file or directory.
+	DiffMode                           // permission and special mode bits.
+	DiffOwner                          // user or group id.
+	DiffMtime                          // modification time.
+	DiffContent                        // contents of a regular file.
+	DiffTarget                         // target of a symbolic link.
+)
+
+var diffFieldNames = []string{"type", "mode", "owner", "mtime", "content", "target"}
+
+func (f DiffFields) String() string {
+	var names []string
+	for i, name := range diffFieldNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// DiffOptions configures DiffWithOptions.
+type DiffOptions struct {
+	// IgnoreTimes does not compare modification times.
+	IgnoreTimes bool
+
+	// Ignore lists glob patterns, in the syntax of path.Match, of files to
+	// leave out of the comparison together with their contents. A pattern
+	// without a slash matches the base name at any depth; other patterns
+	// match the path relative to the root of the comparison.
+	Ignore []string
+}
+
+// Difference is a file that differs between two filesystems compared by Diff.
+type Difference struct {
+	Path   string     // path of the file in both filesystems.
+	Kind   ChangeKind // ChangeAdded if only in b, ChangeDeleted if only in a.
+	Fields DiffFields // attributes that differ, for ChangeModified.
+
+	// Diff is a unified diff of the contents of a modified text file, empty
+	// for other files.
+	Diff string
+}
+
+func (d Difference) String() string {
+	if d.Kind == ChangeModified {
+		return fmt.Sprintf("%s %s (%s)", d.Kind, d.Path, d.Fields)
+	}
+	return fmt.Sprintf("%s %s", d.Kind, d.Path)
+}
+
+// Differences is the result of Diff.
+type Differences []Difference
+
+// String lists the differences one per line, followed by the unified diffs of
+// the contents of text files.
+func (ds Differences) String() string {
+	var b strings.Builder
+	for _, d := range ds {
+		b.WriteString(d.String())
+		b.WriteByte('\n')
+	}
+	for _, d := range ds {
+		b.WriteString(d.Diff)
+	}
+	return b.String()
+}
+
+// Diff compares the trees rooted at the directory root in a and b and returns
+// the files that were added, removed or modified going from a to b, sorted by
+// path. The contents of added and removed directories are listed too, also
+// when a directory is replaced by another type of file or replaces one.
+// Symbolic links are compared, not followed. Owners are compared only if both
+// filesystems report them.
+func Diff(a, b absfs.FileSystem, root string) (Differences, error) {
+	return DiffWithOptions(a, b, root, DiffOptions{})
+}
+
+// DiffWithOptions is like Diff, configured by opts.
+func DiffWithOptions(a, b absfs.FileSystem, root string, opts DiffOptions) (Differences, error) {
+	for _, fs := range []absfs.FileSystem{a, b} {
+		info, err := lstatOf(fs, root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, &os.PathError{Op: "diff", Path: root, Err: syscall.ENOTDIR}
+		}
+	}
+	d := &differ{a: a, b: b, root: root, opts: opts}
+	err := d.dir("")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(d.diffs, func(i, j int) bool {
+		return d.diffs[i].Path < d.diffs[j].Path
+	})
+	return d.diffs, nil
+}
+
+// differ holds the state of a comparison by DiffWithOptions. Paths are
+// relative to root.
+type differ struct {
+	a, b  absfs.FileSystem
+	root  string
+	opts  DiffOptions
+	diffs Differences
+}
+
+func (d *differ) path(rel string) string {
+	return filepath.Join(d.root, rel)
+}
+
+func (d *differ) ignored(rel string) bool {
+	for _, pattern := range d.opts.Ignore {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = filepath.Base(rel)
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dir compares the entries of the directory rel.
+func (d *differ) dir(rel string) error {
+	as, err := entries(d.a, d.path(rel))
+	if err != nil {
+		return err
+	}
+	bs, err := entries(d.b, d.path(rel))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(as)+len(bs))
+	for name := range as {
+		names = append(names, name)
+	}
+	for name := range bs {
+		if _, ok := as[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := filepath.Join(rel, name)
+		if d.ignored(child) {
+			continue
+		}
+		ia, inA := as[name]
+		ib, inB := bs[name]
+		switch {
+		case !inB:
+			err = d.only(d.a, child, ia, ChangeDeleted)
+		case !inA:
+			err = d.only(d.b, child, ib, ChangeAdded)
+		default:
+			err = d.file(child, ia, ib)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// only records the file rel, which is only in fs, and its contents.
+func (d *differ) only(fs absfs.FileSystem, rel string, info os.FileInfo, kind ChangeKind) error {
+	d.diffs = append(d.diffs, Difference{Path: d.path(rel), Kind: kind})
+	if !info.IsDir() {
+		return nil
+	}
+	return d.contents(fs, rel, kind)
+}
+
+// contents records the contents of the directory rel of fs, which are only in
+// fs.
+func (d *differ) contents(fs absfs.FileSystem, rel string, kind ChangeKind) error {
+	infos, err := entries(fs, d.path(rel))
+	if err != nil {
+		return err
+	}
+	for name, info := range infos {
+		child := filepath.Join(rel, name)
+		if d.ignored(child) {
+			continue
+		}
+		err = d.only(fs, child, info, kind)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// file compares the file rel, described by ia in a and ib in b.
+func (d *differ) file(rel string, ia, ib os.FileInfo) error {
+	name := d.path(rel)
+	ma, mb := ia.Mode(), ib.Mode()
+	var fields DiffFields
+	var diff string
+	if ma.Type() != mb.Type() {
+		fields |= DiffType
+	}
+	if ma&^os.ModeType != mb&^os.ModeType {
+		fields |= DiffMode
+	}
+	ua, ga, okA := owner(ia)
+	ub, gb, okB := owner(ib)
+	if okA && okB && (ua != ub || ga != gb) {
+		fields |= DiffOwner
+	}
+	if !d.opts.IgnoreTimes && !ia.ModTime().Equal(ib.ModTime()) {
+		fields |= DiffMtime
+	}
+
+	switch {
+	case fields&DiffType != 0:
+		// a directory replaced by another type of file, or replacing one,
+		// had its contents removed or added
+		var err error
+		if ma.IsDir() {
+			err = d.contents(d.a, rel, ChangeDeleted)
+		} else if mb.IsDir() {
+			err = d.contents(d.b, rel, ChangeAdded)
+		}
+		if err != nil {
+			return err
+		}
+	case ma.IsDir():
+		err := d.dir(rel)
+		if err != nil {
+			return err
+		}
+	case ma&os.ModeSymlink != 0:
+		ta, err := readlinkOf(d.a, name)
+		if err != nil {
+			return err
+		}
+		tb, err := readlinkOf(d.b, name)
+		if err != nil {
+			return err
+		}
+		if ta != tb {
+			fields |= DiffTarget
+		}
+	case ma.IsRegular():
+		ca, err := readAll(d.a, name)
+		if err != nil {
+			return err
+		}
+		cb, err := readAll(d.b, name)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(ca, cb) {
+			fields |= DiffContent
+			if isText(ca) && isText(cb) {
+				diff = UnifiedDiff(name, ca, cb)
+			}
+		}
+	}
+
+	if fields != 0 {
+		d.diffs = append(d.diffs, Difference{Path: name, Kind: ChangeModified, Fields: fields, Diff: diff})
+	}
+	return nil
+}
+
+// entries returns the entries of the directory name of fs by name, without
+// "." and "..".
+func entries(fs absfs.FileSystem, name string) (map[string]os.FileInfo, error) {
+	infos, err := readdir(fs, name)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	m := make(map[string]os.FileInfo, len(infos))
+	for _, info := range infos {
+		if info.Name() == "." || info.Name() == ".." {
+			continue
+		}
+		m[info.Name()] = info
+	}
+	return m, nil
+}
+
+// lstatOf is Lstat in fs, or Stat if fs does not support symbolic links.
+func lstatOf(fs absfs.FileSystem, name string) (os.FileInfo, error) {
+	if sl, ok := fs.(absfs.SymLinker); ok {
+		return sl.Lstat(name)
+	}
+	return fs.Stat(name)
+}
+
+func readlinkOf(fs absfs.FileSystem, name string) (string, error) {
+	sl, ok := fs.(absfs.SymLinker)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return sl.Readlink(name)
+}
+
+// owner returns the user and group id of the file described by info, and false
+// if info does not report them.
+func owner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	if stat, ok := StatOf(info); ok {
+		return stat.Uid, stat.Gid, true
+	}
+	return sysOwner(info.Sys())
+}
+
+// isText reports whether data looks like text, for rendering a unified diff.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// diffContext is the number of unchanged lines shown around changes in a
+// unified diff.
+const diffContext = 3
+
+// UnifiedDiff returns the differences between the lines of a and b in the
+// unified format of diff -u, labeling them a/name and b/name. It returns an
+// empty string if a and b are equal.
+func UnifiedDiff(name string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	la, lb := lines(a), lines(b)
+	ops := diffLines(la, lb)
+
+	var out strings.Builder
+	name = strings.TrimPrefix(name, "/")
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+	for i := 0; i < len(ops); {
+		// find the next change and the end of its hunk
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end += diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		hunk := ops[start:end]
+		aStart, bStart := hunk[0].a, hunk[0].b
+		var aLen, bLen int
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, op := range hunk {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the start line, counting from 1, and number of lines of
+// one side of a hunk.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// lines splits data into lines, keeping their line endings.
+func lines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	ls := strings.SplitAfter(string(data), "\n")
+	if ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
+
+// diffOp is a line of an edit script: ' ' keeps, '-' removes and '+' adds a
+// line. a and b are the indexes of the line in each input, or of the next
+// line for lines missing from that input.
+type diffOp struct {
+	kind byte
+	line string
+	a, b int
+}
+
+// diffLines returns a shortest edit script turning a into b, found with the
+// linear space variant of Myers' algorithm. Within each run of changes, the
+// removed lines come before the added ones.
+func diffLines(a, b []string) []diffOp {
+	s := &editScript{a: a, b: b}
+	s.diff(0, len(a), 0, len(b))
+
+	// move the removed lines of each run of changes first
+	ops := make([]diffOp, 0, len(s.ops))
+	for i := 0; i < len(s.ops); {
+		if s.ops[i].kind == ' ' {
+			ops = append(ops, s.ops[i])
+			i++
+			continue
+		}
+		run := i
+		for i < len(s.ops) && s.ops[i].kind != ' ' {
+			i++
+		}
+		x, y := s.ops[run].a, s.ops[run].b
+		for _, op := range s.ops[run:i] {
+			if op.kind == '-' {
+				ops = append(ops, diffOp{'-', op.line, x, y})
+				x++
+			}
+		}
+		for _, op := range s.ops[run:i] {
+			if op.kind == '+' {
+				ops = append(ops, diffOp{'+', op.line, x, y})
+				y++
+			}
+		}
+	}
+	return ops
+}
+
+// editScript builds the edit script of diffLines.
+type editScript struct {
+	a, b []string
+	ops  []diffOp
+}
+
+func (s *editScript) keep(i, j int) {
+	s.ops = append(s.ops, diffOp{' ', s.a[i], i, j})
+}
+
+// diff appends the edit script turning a[a0:a1] into b[b0:b1].
+func (s *editScript) diff(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && s.a[a0] == s.b[b0] {
+		s.keep(a0, b0)
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a0 < a1 && b0 < b1 && s.a[a1-1] == s.b[b1-1] {
+		a1--
+		b1--
+		suffix++
+	}
+
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			s.ops = append(s.ops, diffOp{'+', s.b[j], a0, j})
+		}
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			s.ops = append(s.ops, diffOp{'-', s.a[i], i, b0})
+		}
+	default:
+		// without a common prefix or suffix and with lines on both sides,
+		// at least two edits are needed, so both halves are smaller
+		x, y, u, v := s.middleSnake(a0, a1, b0, b1)
+		s.diff(a0, x, b0, y)
+		for ; x < u; x, y = x+1, y+1 {
+			s.keep(x, y)
+		}
+		s.diff(u, a1, v, b1)
+	}
+
+	for k := 0; k < suffix; k++ {
+		s.keep(a1+k, b1+k)
+	}
+}
+
+// middleSnake returns the start (x, y) and end (u, v) of the middle snake of
+// a shortest edit script turning a[a0:a1] into b[b0:b1]: the diagonal of
+// unchanged lines where the searches from both ends meet.
+func (s *editScript) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int) {
+	a, b := s.a[a0:a1], s.b[b0:b1]
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+
+	// forward[off+k] is the furthest x reached from the start on diagonal
+	// k = x-y, backward[off+k] the furthest distance from the end on
+	// diagonal k of the reversed inputs.
+	off := max + 1
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			x0 := forward[off+k-1] + 1
+			if k == -d || k != d && forward[off+k-1] < forward[off+k+1] {
+				x0 = forward[off+k+1]
+			}
+			y0 := x0 - k
+			x1, y1 := x0, y0
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			forward[off+k] = x1
+			if c := delta - k; odd && c >= 1-d && c <= d-1 && x1+backward[off+c] >= n {
+				return a0 + x0, b0 + y0, a0 + x1, b0 + y1
+			}
+		}
+		for c := -d; c <= d; c += 2 {
+			x0 := backward[off+c-1] + 1
+			if c == -d || c != d && backward[off+c-1] < backward[off+c+1] {
+				x0 = backward[off+c+1]
+			}
+			y0 := x0 - c
+			x1, y1 := x0, y0
+			for x1 < n && y1 < m && a[n-1-x1] == b[m-1-y1] {
+				x1++
+				y1++
+			}
+			backward[off+c] = x1
+			if k := delta - c; !odd && k >= -d && k <= d && forward[off+k]+x1 >= n {
+				return a0 + n - x1, b0 + m - y1, a0 + n - x0, b0 + m - y0
+			}
+		}
+	}
+	panic("memfs: no middle snake")
+}
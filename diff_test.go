@@ -0,0 +1,289 @@
+package memfs_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+	"github.com/absfs/osfs"
+)
+
+func TestDiff(t *testing.T) {
+	clock := memfs.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a, err := memfs.NewFSWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"/src", "/old/sub", "/build"} {
+		err = a.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, a, "/src/main.go", "package main\n\nfunc main() {\n}\n")
+	writeFile(t, a, "/src/mode", "mode")
+	writeFile(t, a, "/src/same", "same")
+	writeFile(t, a, "/old/sub/file", "old")
+	writeFile(t, a, "/build/out.o", "object")
+	err = a.Symlink("src", "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := memfs.Overlay(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/src/main.go", "package main\n\nfunc main() {\n\tprintln()\n}\n")
+	err = fs.Chmod("/src/mode", 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.RemoveAll("/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/new", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/new/file", "new")
+	writeFile(t, fs, "/build/out.o", "changed")
+	err = fs.Remove("/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("new", "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := memfs.DiffWithOptions(a, fs, "/", memfs.DiffOptions{
+		IgnoreTimes: true,
+		Ignore:      []string{"*.o"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []memfs.Difference{
+		{Path: "/link", Kind: memfs.ChangeModified, Fields: memfs.DiffTarget},
+		{Path: "/new", Kind: memfs.ChangeAdded},
+		{Path: "/new/file", Kind: memfs.ChangeAdded},
+		{Path: "/old", Kind: memfs.ChangeDeleted},
+		{Path: "/old/sub", Kind: memfs.ChangeDeleted},
+		{Path: "/old/sub/file", Kind: memfs.ChangeDeleted},
+		{Path: "/src/main.go", Kind: memfs.ChangeModified, Fields: memfs.DiffContent},
+		{Path: "/src/mode", Kind: memfs.ChangeModified, Fields: memfs.DiffMode},
+	}
+	if len(diffs) != len(want) {
+		t.Fatalf("wrong differences:\n%s", diffs)
+	}
+	for i, d := range diffs {
+		d.Diff = ""
+		if d != want[i] {
+			t.Errorf("difference %d: got %v, want %v", i, d, want[i])
+		}
+	}
+
+	unified := "--- a/src/main.go\n" +
+		"+++ b/src/main.go\n" +
+		"@@ -1,4 +1,5 @@\n" +
+		" package main\n" +
+		" \n" +
+		" func main() {\n" +
+		"+\tprintln()\n" +
+		" }\n"
+	if diffs[6].Diff != unified {
+		t.Errorf("wrong unified diff:\n%s", diffs[6].Diff)
+	}
+
+	// Without options timestamps are compared too.
+	err = fs.Chtimes("/src/same", time.Unix(0, 0), time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = memfs.Diff(a, fs, "/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, d := range diffs {
+		paths = append(paths, d.Path)
+	}
+	if !equalStrings(paths, []string{"/src/main.go", "/src/mode", "/src/same"}) {
+		t.Errorf("wrong differences:\n%s", diffs)
+	}
+	if d := diffs[len(diffs)-1]; d.Fields != memfs.DiffMtime {
+		t.Errorf("wrong fields %s", d.Fields)
+	}
+
+	if diffs, _ := memfs.Diff(a, a, "/"); len(diffs) != 0 {
+		t.Errorf("differences with itself:\n%s", diffs)
+	}
+	_, err = memfs.Diff(a, fs, "/src/mode")
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20"
+	new := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n"
+	writeFile(t, a, "/f", old)
+	writeFile(t, b, "/f", new)
+	writeFile(t, a, "/bin", "\x00\x01")
+	writeFile(t, b, "/bin", "\x00\x02")
+
+	diffs, err := memfs.DiffWithOptions(a, b, "/", memfs.DiffOptions{IgnoreTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("wrong differences:\n%s", diffs)
+	}
+	if diffs[0].Diff != "" {
+		t.Errorf("unified diff of a binary file:\n%s", diffs[0].Diff)
+	}
+	want := "--- a/f\n" +
+		"+++ b/f\n" +
+		"@@ -2,7 +2,7 @@\n" +
+		" 2\n" +
+		" 3\n" +
+		" 4\n" +
+		"-5\n" +
+		"+five\n" +
+		" 6\n" +
+		" 7\n" +
+		" 8\n" +
+		"@@ -17,4 +17,4 @@\n" +
+		" 17\n" +
+		" 18\n" +
+		" 19\n" +
+		"-20\n" +
+		"\\ No newline at end of file\n" +
+		"+20\n"
+	if diffs[1].Diff != want {
+		t.Errorf("wrong unified diff:\n%s\nwant:\n%s", diffs[1].Diff, want)
+	}
+}
+
+func TestDiffTypeChange(t *testing.T) {
+	a, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memfs.Build(a,
+		memfs.Dir("dir", 0755, memfs.Dir("sub", 0755, memfs.Regular("file", "", 0644))),
+		memfs.Regular("file", "", 0644),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memfs.Build(b,
+		memfs.Regular("dir", "", 0644),
+		memfs.Dir("file", 0755, memfs.Regular("new", "", 0644)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := memfs.DiffWithOptions(a, b, "/", memfs.DiffOptions{IgnoreTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"modified /dir (type,mode)",
+		"deleted /dir/sub",
+		"deleted /dir/sub/file",
+		"modified /file (type,mode)",
+		"added /file/new",
+	}
+	var got []string
+	for _, d := range diffs {
+		got = append(got, d.String())
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("wrong differences %q, want %q", got, want)
+	}
+}
+
+func TestUnifiedDiffLarge(t *testing.T) {
+	// the edit script takes space linear in the number of lines
+	var a, b strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&a, "%d\n", i)
+		if i == 50000 {
+			b.WriteString("changed\n")
+			continue
+		}
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	want := "--- a/f\n" +
+		"+++ b/f\n" +
+		"@@ -49998,7 +49998,7 @@\n" +
+		" 49997\n" +
+		" 49998\n" +
+		" 49999\n" +
+		"-50000\n" +
+		"+changed\n" +
+		" 50001\n" +
+		" 50002\n" +
+		" 50003\n"
+	if got := memfs.UnifiedDiff("f", []byte(a.String()), []byte(b.String())); got != want {
+		t.Errorf("wrong unified diff:\n%s", got)
+	}
+
+	// removed lines come before added ones
+	got := memfs.UnifiedDiff("f", []byte("a\nb\nc\n"), []byte("x\ny\nc\n"))
+	want = "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n-a\n-b\n+x\n+y\n c\n"
+	if got != want {
+		t.Errorf("wrong unified diff:\n%s", got)
+	}
+}
+
+func TestDiffOS(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "file"), []byte("disk\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ofs, err := osfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, filepath.ToSlash(filepath.Join(dir, "file")), "memory\n")
+
+	diffs, err := memfs.DiffWithOptions(ofs, fs, dir, memfs.DiffOptions{IgnoreTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Fields&memfs.DiffContent == 0 {
+		t.Fatalf("wrong differences:\n%s", diffs)
+	}
+}
@@ -0,0 +1,194 @@
+package memfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DumpOptions controls the listing written by Dump.
+type DumpOptions struct {
+	// Hash appends the SHA-256 of the content of regular files, abbreviated
+	// to 16 hexadecimal digits.
+	Hash bool
+
+	// Preview appends the quoted content of regular files of at most Preview
+	// bytes. Zero disables previews.
+	Preview int
+}
+
+// Dump writes a listing of the tree rooted at root to w, for debugging. Each
+// file is listed on one line with its inode number, mode in the style of ls,
+// link count, owner, size and modification time in UTC, followed by its name
+// drawn in the style of tree and, for symbolic links, their target. Entries are
+// sorted by name and symbolic links are not followed, so the listing of a tree
+// is deterministic. Dump reads the tree as root without updating access times.
+func (fs *FileSystem) Dump(w io.Writer, root string, opts DumpOptions) error {
+	d := &dumper{fs: fs.View(), opts: opts}
+	d.fs.Uid, d.fs.Gid, d.fs.Groups = 0, 0, nil
+	d.fs.AtimePolicy = Noatime
+
+	info, err := d.fs.Lstat(root)
+	if err != nil {
+		return err
+	}
+	err = d.add(root, root, "", "", info)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, d.format())
+	return err
+}
+
+// dumper collects the rows of a listing.
+type dumper struct {
+	fs   *FileSystem
+	opts DumpOptions
+	rows [][]string // the columns of each row, the last one the name.
+}
+
+// dumpColumns is the number of columns of a row.
+const dumpColumns = 8
+
+// add adds the rows of the file p, listed as name, and of its contents.
+// prefix is the tree drawing of the row, and indent that of its contents.
+func (d *dumper) add(p, name, prefix, indent string, info os.FileInfo) error {
+	stat, ok := StatOf(info)
+	if !ok {
+		stat = &Stat{Mode: info.Mode(), Size: info.Size(), Mtime: info.ModTime(), Nlink: 1}
+		if uid, gid, ok := sysOwner(info.Sys()); ok {
+			stat.Uid, stat.Gid = uid, gid
+		}
+	}
+	label := prefix + name
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		target, err := d.fs.Readlink(p)
+		if err != nil {
+			return err
+		}
+		label += " -> " + target
+	case mode.IsRegular() && (d.opts.Hash || d.opts.Preview > 0):
+		data, err := readAll(d.fs, p)
+		if err != nil {
+			return err
+		}
+		if d.opts.Hash {
+			sum := sha256.Sum256(data)
+			label += " sha256:" + hex.EncodeToString(sum[:8])
+		}
+		if d.opts.Preview > 0 && len(data) <= d.opts.Preview {
+			label += " " + strconv.Quote(string(data))
+		}
+	}
+	d.rows = append(d.rows, []string{
+		strconv.FormatUint(stat.Ino, 10),
+		modeString(mode),
+		strconv.FormatUint(stat.Nlink, 10),
+		strconv.FormatUint(uint64(stat.Uid), 10),
+		strconv.FormatUint(uint64(stat.Gid), 10),
+		strconv.FormatInt(stat.Size, 10),
+		stat.Mtime.UTC().Format("2006-01-02 15:04:05"),
+		label,
+	})
+	if !mode.IsDir() {
+		return nil
+	}
+
+	infos, err := readdir(d.fs, p)
+	if err != nil {
+		return &os.PathError{Op: "dump", Path: p, Err: err}
+	}
+	children := infos[:0]
+	for _, info := range infos {
+		if info.Name() != "." && info.Name() != ".." {
+			children = append(children, info)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	for i, child := range children {
+		branch, next := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, next = "└── ", "    "
+		}
+		err = d.add(filepath.Join(p, child.Name()), child.Name(), indent+branch, indent+next, child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// format returns the rows as aligned text, with numbers aligned right.
+func (d *dumper) format() string {
+	var widths [dumpColumns - 1]int
+	for _, row := range d.rows {
+		for i, col := range row[:dumpColumns-1] {
+			if len(col) > widths[i] {
+				widths[i] = len(col)
+			}
+		}
+	}
+	var b strings.Builder
+	for _, row := range d.rows {
+		for i, col := range row[:dumpColumns-1] {
+			if i == 1 || i == dumpColumns-2 {
+				fmt.Fprintf(&b, "%-*s ", widths[i], col)
+				continue
+			}
+			fmt.Fprintf(&b, "%*s ", widths[i], col)
+		}
+		b.WriteString(row[dumpColumns-1])
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// modeString formats mode like ls -l, for example "drwxr-xr-x" or
+// "-rwsr-x--T".
+func modeString(mode os.FileMode) string {
+	b := []byte("?rwxrwxrwx")
+	switch {
+	case mode&os.ModeDir != 0:
+		b[0] = 'd'
+	case mode&os.ModeSymlink != 0:
+		b[0] = 'l'
+	case mode&os.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case mode&os.ModeSocket != 0:
+		b[0] = 's'
+	case mode&os.ModeCharDevice != 0:
+		b[0] = 'c'
+	case mode&os.ModeDevice != 0:
+		b[0] = 'b'
+	default:
+		b[0] = '-'
+	}
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) == 0 {
+			b[i+1] = '-'
+		}
+	}
+	special := func(i int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if b[i] == '-' {
+			c -= 'a' - 'A'
+		}
+		b[i] = c
+	}
+	special(3, mode&os.ModeSetuid != 0, 's')
+	special(6, mode&os.ModeSetgid != 0, 's')
+	special(9, mode&os.ModeSticky != 0, 't')
+	return string(b)
+}
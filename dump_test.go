@@ -0,0 +1,73 @@
+package memfs_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestDump(t *testing.T) {
+	clock := memfs.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	fs, err := memfs.NewFSWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.AtimePolicy = memfs.StrictAtime
+	err = memfs.Build(fs,
+		memfs.Dir("a", 0755,
+			memfs.Regular("b.txt", "hi\n", 0644).Owner(1000, 100),
+			memfs.Symlink("c", "b.txt"),
+			memfs.Hardlink("d", "/a/b.txt"),
+			memfs.Dir("e", 0700|os.ModeSticky),
+		),
+		memfs.Regular("large", strings.Repeat("x", 100), os.ModeSetuid|0755),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := fs.Stat("/a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+
+	var b strings.Builder
+	err = fs.Dump(&b, "/", memfs.DumpOptions{Hash: true, Preview: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `1 drwxr-xr-x 3    0   0   0 2020-01-01 00:00:00 /
+2 drwxr-xr-x 3    0   0   0 2020-01-01 00:00:00 ├── a
+3 -rw-r--r-- 2 1000 100   3 2020-01-01 00:00:00 │   ├── b.txt sha256:98ea6e4f216f2fb4 "hi\n"
+4 lrwxrwxrwx 1    0   0   5 2020-01-01 00:00:00 │   ├── c -> b.txt
+3 -rw-r--r-- 2 1000 100   3 2020-01-01 00:00:00 │   ├── d sha256:98ea6e4f216f2fb4 "hi\n"
+5 drwx-----T 2    0   0   0 2020-01-01 00:00:00 │   └── e
+6 -rwsr-xr-x 1    0   0 100 2020-01-01 00:00:00 └── large sha256:09ecb6ebc8bcefc7
+`
+	if b.String() != want {
+		t.Errorf("wrong dump:\n%s", memfs.UnifiedDiff("dump", []byte(want), []byte(b.String())))
+	}
+
+	after, err := fs.Stat("/a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !atime(t, after).Equal(atime(t, before)) {
+		t.Errorf("dump changed the access time")
+	}
+
+	b.Reset()
+	err = fs.Dump(&b, "/a/c", memfs.DumpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := b.String(); s != "4 lrwxrwxrwx 1 0 0 5 2020-01-01 00:00:00 /a/c -> b.txt\n" {
+		t.Errorf("wrong dump of a symbolic link %q", s)
+	}
+	if err := fs.Dump(&b, "/missing", memfs.DumpOptions{}); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
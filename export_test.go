@@ -0,0 +1,4 @@
+package memfs
+
+// ErrNoAttr is the error for a missing extended attribute on this system.
+var ErrNoAttr = errNoAttr
@@ -0,0 +1,374 @@
+package memfs_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+// checkTree checks the invariants of the tree of fs: the "." and ".." entries
+// of each directory refer to it and its parent, link counts match the entries
+// referring to each inode, names are valid and unique, and the sizes of
+// regular files match their contents. It also runs fs.Check.
+func checkTree(t *testing.T, fs *memfs.FileSystem) {
+	t.Helper()
+	if r := fs.Check(); !r.OK() {
+		t.Errorf("check: %v", r)
+	}
+	root := fs.View()
+	root.Uid, root.Gid = 0, 0
+
+	links := make(map[uint64]int)     // number of entries referring to each inode.
+	nlinks := make(map[uint64]uint64) // link count of each non-directory.
+	var walk func(dir string, ino, parent uint64)
+	walk = func(dir string, ino, parent uint64) {
+		infos := readdirInfos(t, root, dir)
+		seen := make(map[string]bool)
+		subdirs := uint64(0)
+		for _, info := range infos {
+			name := info.Name()
+			st, _ := memfs.StatOf(info)
+			switch {
+			case seen[name]:
+				t.Errorf("%s: duplicate entry %q", dir, name)
+			case name == "" || strings.Contains(name, "/"):
+				t.Errorf("%s: invalid name %q", dir, name)
+			case name == ".":
+				if st.Ino != ino {
+					t.Errorf("%s: . is inode %d, want %d", dir, st.Ino, ino)
+				}
+			case name == "..":
+				if st.Ino != parent {
+					t.Errorf("%s: .. is inode %d, want %d", dir, st.Ino, parent)
+				}
+			case info.IsDir():
+				subdirs++
+				walk(path.Join(dir, name), st.Ino, ino)
+			default:
+				links[st.Ino]++
+				nlinks[st.Ino] = st.Nlink
+				if info.Mode().IsRegular() {
+					data, err := readAllFrom(root, path.Join(dir, name))
+					if err != nil {
+						t.Errorf("%s: %v", path.Join(dir, name), err)
+					} else if int64(len(data)) != info.Size() {
+						t.Errorf("%s: size %d, but %d bytes of data", path.Join(dir, name), info.Size(), len(data))
+					}
+				}
+			}
+			seen[name] = true
+		}
+		info, err := root.Lstat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st, _ := memfs.StatOf(info); st.Nlink != 2+subdirs {
+			t.Errorf("%s: link count %d with %d subdirectories", dir, st.Nlink, subdirs)
+		}
+	}
+	info, err := root.Lstat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := memfs.StatOf(info)
+	walk("/", st.Ino, st.Ino)
+	for ino, n := range links {
+		if nlinks[ino] != uint64(n) {
+			t.Errorf("inode %d: link count %d with %d entries", ino, nlinks[ino], n)
+		}
+	}
+}
+
+func readdirInfos(t *testing.T, fs *memfs.FileSystem, dir string) []os.FileInfo {
+	t.Helper()
+	f, err := fs.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return infos
+}
+
+func readAllFrom(fs *memfs.FileSystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// FuzzPaths applies operations to arbitrary paths built from a and b.
+func FuzzPaths(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, "a", "b")
+	f.Add([]byte{2, 18, 7, 40, 56}, "dir/sub", "../x")
+	f.Add([]byte{1, 25, 3, 41, 6}, "/", "//a/./b/")
+	f.Add([]byte{7, 23, 39, 55, 71}, "link/..", "")
+	f.Add([]byte("17\xad\xd2"), "0", "0")
+	f.Fuzz(func(t *testing.T, ops []byte, a, b string) {
+		fs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = memfs.Build(fs,
+			memfs.Dir("dir", 0755,
+				memfs.Regular("file", "content", 0644),
+				memfs.Dir("sub", 0700),
+			),
+			memfs.Symlink("link", "dir"),
+			memfs.Hardlink("hard", "dir/file"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{a, b, a + "/" + b, "/" + a, "dir/" + a, "link/" + b, ".."}
+		for i, op := range ops {
+			p := names[int(op>>4)%len(names)]
+			q := names[(int(op>>4)+i+1)%len(names)]
+			perm := os.FileMode(op) * 0111 & 0777
+			switch op % 12 {
+			case 0:
+				if file, err := fs.OpenFile(p, int(op)<<2&(os.O_CREATE|os.O_EXCL|os.O_TRUNC|os.O_APPEND)|int(op)%3, perm); err == nil {
+					file.Write([]byte(q))
+					file.Close()
+				}
+			case 1:
+				fs.Mkdir(p, perm|0700)
+			case 2:
+				fs.MkdirAll(p, perm|0700)
+			case 3:
+				fs.Rename(p, q)
+			case 4:
+				fs.Remove(p)
+			case 5:
+				fs.RemoveAll(p)
+			case 6:
+				fs.Symlink(q, p)
+			case 7:
+				fs.Chdir(p)
+			case 8:
+				fs.Link(p, q)
+			case 9:
+				fs.Truncate(p, int64(op)-128)
+			case 10:
+				fs.Chmod(p, perm)
+			default:
+				fs.Stat(p)
+				fs.Lstat(p)
+				fs.Readlink(p)
+			}
+		}
+		checkTree(t, fs)
+		if _, err := fs.Getwd(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("getwd: %v", err)
+		}
+	})
+}
+
+// FuzzFile applies reads, writes, seeks and truncations with arbitrary offsets
+// to a file, comparing its contents with a model.
+func FuzzFile(f *testing.F) {
+	const limit = 1 << 16
+	f.Add([]byte{0, 1, 2, 3, 4, 5}, int64(3), int64(10), "data")
+	f.Add([]byte{1, 1, 3, 0}, int64(-1), int64(-5), "x")
+	f.Add([]byte{2, 5, 0, 4}, int64(1<<40), int64(-1<<40), "")
+	f.Add([]byte{2, 0, 1, 3}, int64(math.MaxInt64), int64(1<<62), "x")
+	f.Add([]byte{1, 3, 8, 0}, int64(limit-2), int64(limit+1), "data")
+	f.Fuzz(func(t *testing.T, ops []byte, off, size int64, data string) {
+		fs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// sizes and offsets span the whole range, but files stay small
+		fs.FileSizeLimit = limit
+		file, err := fs.OpenFile("/file", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		var model []byte
+		offset := int64(0)
+		// write checks the result of writing data at at, cut short at the
+		// limit, and applies it to the model.
+		write := func(op string, at int64, n int, err error) {
+			want := int64(len(data))
+			if want > limit-at {
+				want = limit - at
+			}
+			if want < 0 {
+				want = 0
+			}
+			if int64(n) != want || (n < len(data)) != errors.Is(err, syscall.EFBIG) {
+				t.Fatalf("%s %d: %d, %v, want %d", op, at, n, err, want)
+			}
+			if want == 0 {
+				return
+			}
+			if end := at + want; end > int64(len(model)) {
+				model = append(model, make([]byte, end-int64(len(model)))...)
+			}
+			copy(model[at:], data[:want])
+		}
+		for i, op := range ops {
+			off, size := off+int64(i), size-int64(i)
+			switch op % 6 {
+			case 0:
+				n, err := file.Write([]byte(data))
+				write("write", offset, n, err)
+				offset += int64(n)
+			case 1:
+				n, err := file.WriteAt([]byte(data), off)
+				if off < 0 {
+					if err == nil {
+						t.Fatalf("writeat %d: expected an error", off)
+					}
+					continue
+				}
+				write("writeat", off, n, err)
+			case 2:
+				whence := int(op/6) % 3
+				want := off + []int64{0, offset, int64(len(model))}[whence]
+				got, err := file.Seek(off, whence)
+				if want < 0 {
+					if err == nil {
+						t.Fatalf("seek %d %d: expected an error", off, whence)
+					}
+					continue
+				}
+				if err != nil || got != want {
+					t.Fatalf("seek %d %d: %d, %v, want %d", off, whence, got, err, want)
+				}
+				offset = got
+			case 3:
+				err := file.Truncate(size)
+				if size < 0 || size > limit {
+					if err == nil {
+						t.Fatalf("truncate %d: expected an error", size)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("truncate %d: %v", size, err)
+				}
+				if size < int64(len(model)) {
+					model = model[:size]
+				} else {
+					model = append(model, make([]byte, size-int64(len(model)))...)
+				}
+			case 4:
+				buf := make([]byte, len(data)+1)
+				n, err := file.ReadAt(buf, off)
+				switch {
+				case off < 0:
+					if err == nil {
+						t.Fatalf("readat %d: expected an error", off)
+					}
+				case off >= int64(len(model)):
+					if n != 0 {
+						t.Fatalf("readat %d past the end: %d", off, n)
+					}
+				case !bytes.Equal(buf[:n], model[off:off+int64(n)]):
+					t.Fatalf("readat %d: %q, want a prefix of %q", off, buf[:n], model[off:])
+				}
+			default:
+				buf := make([]byte, len(data)+1)
+				n, _ := file.Read(buf)
+				if offset < int64(len(model)) && !bytes.Equal(buf[:n], model[offset:offset+int64(n)]) {
+					t.Fatalf("read at %d: %q, want a prefix of %q", offset, buf[:n], model[offset:])
+				}
+				offset += int64(n)
+			}
+		}
+
+		if err := file.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(model)) {
+			t.Errorf("size %d, want %d", info.Size(), len(model))
+		}
+		got, err := readAllFrom(fs, "/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, model) {
+			t.Errorf("content %q, want %q", got, model)
+		}
+		checkTree(t, fs)
+	})
+}
+
+// FuzzReaddir reads a directory in chunks of arbitrary counts, checking that
+// every entry is returned exactly once.
+func FuzzReaddir(f *testing.F) {
+	f.Add(uint8(5), 2, 0, 100)
+	f.Add(uint8(0), -1, 1, 3)
+	f.Add(uint8(3), 10, 10, -7)
+	f.Fuzz(func(t *testing.T, entries uint8, n1, n2, n3 int) {
+		fs, err := memfs.NewFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Mkdir("/dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{".", ".."}
+		for i := 0; i < int(entries%32); i++ {
+			name := string(rune('a' + i))
+			writeFile(t, fs, "/dir/"+name, "")
+			want = append(want, name)
+		}
+
+		dir, err := fs.Open("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dir.Close()
+		var got []string
+		for i, n := range []int{n1, n2, n3, -1} {
+			var names []string
+			if i%2 == 0 {
+				infos, err := dir.Readdir(n)
+				if err != nil && err != io.EOF {
+					t.Fatal(err)
+				}
+				for _, info := range infos {
+					names = append(names, info.Name())
+				}
+			} else {
+				names, err = dir.Readdirnames(n)
+				if err != nil && err != io.EOF {
+					t.Fatal(err)
+				}
+			}
+			if n > 0 && len(names) > n {
+				t.Errorf("read %d entries with count %d", len(names), n)
+			}
+			got = append(got, names...)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, "/") != strings.Join(want, "/") {
+			t.Errorf("got entries %q, want %q", got, want)
+		}
+		checkTree(t, fs)
+	})
+}
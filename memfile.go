@@ -29,6 +29,12 @@ func (f *File) Name() string {
 }
 
 func (f *File) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.read(p)
+}
+
+func (f *File) read(p []byte) (int, error) {
 	// if f == nil {
 	// 	panic("nil file handle")
 	// }
@@ -44,6 +50,7 @@ func (f *File) Read(p []byte) (int, error) {
 	if f.node.IsDir() && len(f.data) == 0 {
 		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR} //os.ErrPermission
 	}
+	f.fs.accessed(f.node)
 	if f.offset >= int64(len(f.data)) {
 		return 0, io.EOF
 	}
@@ -55,18 +62,60 @@ func (f *File) Read(p []byte) (int, error) {
 }
 
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
 	if f.flags&absfs.O_ACCESS == os.O_WRONLY {
 		return 0, os.ErrPermission
 	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errNegativeOffset}
+	}
+	// as with pread(2), the offset of f is not changed
+	offset := f.offset
 	f.offset = off
-	return f.Read(b)
+	n, err = f.read(b)
+	f.offset = offset
+	return n, err
 }
 
+// errNegativeOffset is the error of os.File.ReadAt and WriteAt for negative
+// offsets.
+var errNegativeOffset = errors.New("negative offset")
+
 func (f *File) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.write(p)
+}
+
+func (f *File) write(p []byte) (int, error) {
 
 	if f.flags&absfs.O_ACCESS == os.O_RDONLY {
 		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
 	}
+	if f.node == nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.fs.rdonly.Load() {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EROFS}
+	}
+	if f.flags&os.O_APPEND != 0 {
+		f.offset = int64(len(f.data))
+	}
+	// as with write(2), a write crossing the file size limit is cut short
+	// and a write starting at the limit fails
+	if len(p) == 0 {
+		return 0, nil
+	}
+	limit := f.fs.sizeLimit()
+	if f.offset >= limit {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EFBIG}
+	}
+	var err error
+	if int64(len(p)) > limit-f.offset {
+		p = p[:limit-f.offset]
+		err = &os.PathError{Op: "write", Path: f.name, Err: syscall.EFBIG}
+	}
 	data := f.data
 	size := len(p) + int(f.offset)
 	if size > len(data) {
@@ -76,47 +125,81 @@ func (f *File) Write(p []byte) (int, error) {
 	n := copy(data[int(f.offset):], p)
 	f.offset += int64(n)
 	f.data = data
-	return n, nil
+	if n > 0 {
+		f.fs.modified(f.node)
+	}
+	return n, err
 }
 
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: errNegativeOffset}
+	}
+	// as with pwrite(2), the offset of f is not changed
+	offset := f.offset
 	f.offset = off
-	return f.Write(b)
+	n, err = f.write(b)
+	f.offset = offset
+	return n, err
 }
 
 func (f *File) Close() error {
-	err := f.Sync()
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.node == nil {
+		return nil
+	}
+	err := f.sync()
 	if err != nil {
 		return err
 	}
 
+	f.fs.closed(f.node)
 	f.node = nil
 	return nil
 }
 
 func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.node == nil {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EBADF}
+	}
 	switch whence {
 	case io.SeekStart:
-		f.offset = offset
 	case io.SeekCurrent:
-		f.offset += offset
+		offset += f.offset
 	case io.SeekEnd:
-		f.offset = int64(len(f.data)) + offset
+		offset += int64(len(f.data))
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
 	}
-	if f.offset < 0 {
-		f.offset = 0
+	// as with lseek(2), negative offsets fail and leave the offset unchanged
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
 	}
+	f.offset = offset
 	return f.offset, nil
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
 	if f.node == nil {
 		return nil, &os.PathError{Op: "stat", Path: f.name, Err: syscall.EBADF}
 	}
-	return &fileinfo{filepath.Base(f.name), f.node}, nil
+	return f.fs.fileinfo(filepath.Base(f.name), f.node), nil
 }
 
 func (f *File) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.sync()
+}
+
+func (f *File) sync() error {
 	// Guard against nil node (e.g., after Close() has been called)
 	if f.node == nil {
 		return nil
@@ -124,12 +207,16 @@ func (f *File) Sync() error {
 	if f.flags&absfs.O_ACCESS == os.O_RDONLY {
 		return nil
 	}
-	f.fs.data[int(f.node.Ino)] = f.data
+	if f.node.Nlink > 0 {
+		f.fs.data[int(f.node.Ino)] = f.data
+	}
 	f.node.Size = int64(len(f.data))
 	return nil
 }
 
 func (f *File) Readdir(n int) ([]os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
 	if f.flags&absfs.O_ACCESS == os.O_WRONLY {
 		return nil, os.ErrPermission
 	}
@@ -137,25 +224,35 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.EBADF}
 	}
 	if !f.node.IsDir() {
-		return nil, errors.New("not a directory")
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: syscall.ENOTDIR}
 	}
+	f.fs.accessed(f.node)
 	dirs := f.node.Dir
 	if f.diroffset >= len(dirs) {
 		return nil, io.EOF
 	}
-	if n < 1 {
-		n = len(dirs)
-		f.diroffset = 0
+	end := f.dirend(n)
+	infos := make([]os.FileInfo, 0, end-f.diroffset)
+	for _, entry := range dirs[f.diroffset:end] {
+		infos = append(infos, f.fs.fileinfo(entry.Name, entry.Inode))
 	}
-	infos := make([]os.FileInfo, n-f.diroffset)
-	for i, entry := range dirs[f.diroffset:n] {
-		infos[i] = &fileinfo{entry.Name, entry.Inode}
-	}
-	f.diroffset += n
+	f.diroffset = end
 	return infos, nil
 }
 
+// dirend returns the end of the next n directory entries, or of all remaining
+// entries if n < 1.
+func (f *File) dirend(n int) int {
+	end := len(f.node.Dir)
+	if n > 0 && n < end-f.diroffset {
+		end = f.diroffset + n
+	}
+	return end
+}
+
 func (f *File) Readdirnames(n int) ([]string, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
 	var list []string
 	if f.flags&absfs.O_ACCESS == os.O_WRONLY {
 		return list, os.ErrPermission
@@ -164,27 +261,41 @@ func (f *File) Readdirnames(n int) ([]string, error) {
 		return list, &os.PathError{Op: "readdirnames", Path: f.name, Err: syscall.EBADF}
 	}
 	if !f.node.IsDir() {
-		return list, errors.New("not a directory")
+		return list, &os.PathError{Op: "readdirent", Path: f.name, Err: syscall.ENOTDIR}
 	}
+	f.fs.accessed(f.node)
 	dirs := f.node.Dir
 	if f.diroffset >= len(dirs) {
 		return list, io.EOF
 	}
-	if n < 1 {
-		n = len(dirs)
+	end := f.dirend(n)
+	list = make([]string, 0, end-f.diroffset)
+	for _, entry := range dirs[f.diroffset:end] {
+		list = append(list, entry.Name)
 	}
-	list = make([]string, n-f.diroffset)
-	for i, entry := range dirs[f.diroffset:n] {
-		list[i] = entry.Name
-	}
-	f.diroffset += n
+	f.diroffset = end
 	return list, nil
 }
 
 func (f *File) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
 	if f.flags&absfs.O_ACCESS == os.O_RDONLY {
 		return os.ErrPermission
 	}
+	if f.node == nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.fs.rdonly.Load() {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EROFS}
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EINVAL}
+	}
+	if size > f.fs.sizeLimit() {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EFBIG}
+	}
+	f.fs.modified(f.node)
 	if int(size) <= len(f.data) {
 		f.data = f.data[:int(size)]
 		return nil
@@ -196,12 +307,16 @@ func (f *File) Truncate(size int64) error {
 }
 
 func (f *File) WriteString(s string) (n int, err error) {
-	return f.Write([]byte(s))
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.write([]byte(s))
 }
 
+// fileinfo implements os.FileInfo with a snapshot of the file metadata taken
+// when it was created.
 type fileinfo struct {
 	name string
-	node *inode.Inode
+	stat Stat
 }
 
 func (i *fileinfo) Name() string {
@@ -209,21 +324,24 @@ func (i *fileinfo) Name() string {
 }
 
 func (i *fileinfo) Size() int64 {
-	return i.node.Size
+	return i.stat.Size
 }
 
 func (i *fileinfo) ModTime() time.Time {
-	return i.node.Mtime
+	return i.stat.Mtime
 }
 
 func (i *fileinfo) Mode() os.FileMode {
-	return i.node.Mode
+	return i.stat.Mode
 }
 
+// Sys returns a *syscall.Stat_t on Linux and a *Stat on other platforms. The
+// returned value is a copy; use StatOf to access the birth time.
 func (i *fileinfo) Sys() interface{} {
-	return i.node
+	stat := i.stat
+	return stat.sys()
 }
 
 func (i *fileinfo) IsDir() bool {
-	return i.node.IsDir()
+	return i.stat.Mode.IsDir()
 }
@@ -1,12 +1,14 @@
 package memfs
 
 import (
-	"errors"
+	"math"
 	"os"
 	filepath "path" // force forward slash separators on all OSs.
 	pathfilepath "path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -18,29 +20,159 @@ type FileSystem struct {
 	Umask   os.FileMode
 	Tempdir string
 
+	// Uid, Gid and Groups are the credentials used for permission checks and
+	// as the owner of newly created files. NewFS sets them to root, uid and gid
+	// 0 without supplementary groups, so that a FileSystem behaves the same
+	// whoever runs it; use ProcessCredentials to check permissions as the
+	// current process instead.
+	Uid    int
+	Gid    int
+	Groups []int
+
+	// AtimePolicy controls when reads update access times.
+	AtimePolicy AtimePolicy
+
+	// FileSizeLimit is the largest size files may reach through the
+	// FileSystem, like RLIMIT_FSIZE: writes and truncations past it fail with
+	// EFBIG. NewFS sets it to DefaultFileSizeLimit. Sizes are also limited to
+	// what an int can hold.
+	FileSizeLimit int64
+
 	root *inode.Inode
-	cwd  string
-	dir  *inode.Inode
-	ino  *inode.Ino
+	dir  *inode.Inode // working directory
+	wd   string       // working directory relative to a filesystem mounted on dir
+
+	*tree
+}
+
+// tree holds the inodes and their contents, which are shared by a FileSystem
+// and the FileSystems derived from it.
+type tree struct {
+	// mu guards the tree. Exported methods that use the tree hold it for the
+	// whole operation, so the unexported helpers they call must not take it.
+	mu sync.RWMutex
+
+	ino *inode.Ino
+	top *inode.Inode // root of the tree, above the root of any Sub.
+
+	clock Clock
+	dev   uint64
 
 	symlinks map[uint64]string
 	data     [][]byte
+	xattrs   map[uint64]map[string][]byte
+	btimes   map[uint64]time.Time
+
+	acls        map[uint64]ACL
+	defaultACLs map[uint64]ACL
+
+	mounts map[uint64]*mount
+	rdonly atomic.Bool
+
+	open map[uint64]int // number of open Files of each inode.
 }
 
 func NewFS() (*FileSystem, error) {
+	return NewFSWithClock(systemClock{})
+}
+
+// NewFSWithClock returns a new FileSystem that uses clock for every timestamp
+// it sets. Use a FakeClock for reproducible timestamps.
+func NewFSWithClock(clock Clock) (*FileSystem, error) {
 	fs := new(FileSystem)
-	fs.ino = new(inode.Ino)
+	fs.tree = &tree{
+		ino:         new(inode.Ino),
+		clock:       clock,
+		dev:         nextDev(),
+		symlinks:    make(map[uint64]string),
+		data:        make([][]byte, 1),
+		xattrs:      make(map[uint64]map[string][]byte),
+		btimes:      make(map[uint64]time.Time),
+		acls:        make(map[uint64]ACL),
+		defaultACLs: make(map[uint64]ACL),
+		mounts:      make(map[uint64]*mount),
+		open:        make(map[uint64]int),
+	}
 	fs.Tempdir = "/tmp"
 
 	fs.Umask = 0755
-	fs.root = fs.ino.NewDir(fs.Umask)
-	fs.cwd = "/"
+	fs.FileSizeLimit = DefaultFileSizeLimit
+
+	fs.root = fs.newDir(fs.Umask)
 	fs.dir = fs.root
-	fs.data = make([][]byte, 2)
-	fs.symlinks = make(map[uint64]string)
+	fs.top = fs.root
 	return fs, nil
 }
 
+// DefaultFileSizeLimit is the FileSizeLimit of a new FileSystem, 4 GiB.
+const DefaultFileSizeLimit = 1 << 32
+
+// sizeLimit returns the largest size files may reach through fs.
+func (fs *FileSystem) sizeLimit() int64 {
+	limit := fs.FileSizeLimit
+	if limit > math.MaxInt {
+		limit = math.MaxInt
+	}
+	return limit
+}
+
+// ProcessCredentials sets the credentials of fs to those of the current
+// process. It leaves them unchanged on systems without user ids, such as
+// Windows.
+func (fs *FileSystem) ProcessCredentials() {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid < 0 || gid < 0 {
+		return
+	}
+	fs.Uid, fs.Gid = uid, gid
+	fs.Groups, _ = os.Getgroups()
+}
+
+// newInode allocates a new inode and its data slot, owned by the credentials
+// of the FileSystem.
+func (fs *FileSystem) newInode(mode os.FileMode) *inode.Inode {
+	node := fs.ino.New(mode)
+	node.Uid = uint32(fs.Uid)
+	node.Gid = uint32(fs.Gid)
+	fs.stamp(node)
+	fs.data = append(fs.data, []byte{})
+	return node
+}
+
+// newDir allocates a new directory inode and its data slot, owned by the
+// credentials of the FileSystem.
+func (fs *FileSystem) newDir(mode os.FileMode) *inode.Inode {
+	node := fs.ino.NewDir(mode)
+	node.Uid = uint32(fs.Uid)
+	node.Gid = uint32(fs.Gid)
+	fs.stamp(node)
+	fs.data = append(fs.data, []byte{})
+	return node
+}
+
+// release frees the contents and metadata of node once its last link is gone
+// and no File has it open.
+func (fs *FileSystem) release(node *inode.Inode) {
+	if node.Nlink > 0 || fs.open[node.Ino] > 0 {
+		return
+	}
+	fs.data[int(node.Ino)] = nil
+	delete(fs.symlinks, node.Ino)
+	delete(fs.xattrs, node.Ino)
+	delete(fs.btimes, node.Ino)
+	delete(fs.acls, node.Ino)
+	delete(fs.defaultACLs, node.Ino)
+}
+
+// closed drops a File open on node, releasing node if it was unlinked.
+func (fs *FileSystem) closed(node *inode.Inode) {
+	fs.open[node.Ino]--
+	if fs.open[node.Ino] == 0 {
+		delete(fs.open, node.Ino)
+	}
+	fs.release(node)
+}
+
 func (fs *FileSystem) Separator() uint8 {
 	return '/'
 }
@@ -50,59 +182,67 @@ func (fs *FileSystem) ListSeparator() uint8 {
 }
 
 func (fs *FileSystem) Rename(oldpath, newpath string) error {
-	linkErr := &os.LinkError{
-		Op:  "rename",
-		Old: oldpath,
-		New: newpath,
-	}
-	if oldpath == "/" {
-		linkErr.Err = errors.New("the root folder may not be moved or renamed")
-		return linkErr
-	}
-
-	if !filepath.IsAbs(oldpath) {
-		oldpath = filepath.Join(fs.cwd, oldpath)
-	}
-
-	if !filepath.IsAbs(newpath) {
-		newpath = filepath.Join(fs.cwd, newpath)
-	}
-	err := fs.root.Rename(oldpath, newpath)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.rename(fs.dir, fs.relative(oldpath), fs.dir, fs.relative(newpath), 0)
 	if err != nil {
-		linkErr.Err = err
-		return linkErr
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
 	}
 	return nil
 }
 
 func (fs *FileSystem) Chdir(name string) (err error) {
-	if name == "/" {
-		fs.cwd = "/"
-		fs.dir = fs.root
-		return nil
-	}
-	wd := fs.root
-	cwd := name
-	if !filepath.IsAbs(name) {
-		cwd = filepath.Join(fs.cwd, name)
-		wd = fs.dir
-	}
-
-	node, err := wd.Resolve(name)
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	loc, err := fs.lookup(name, true)
 	if err != nil {
 		return &os.PathError{Op: "chdir", Path: name, Err: err}
 	}
-	if !node.IsDir() {
-		return &os.PathError{Op: "chdir", Path: name, Err: errors.New("not a directory")}
+	if loc.mount != nil {
+		info, err := loc.mount.fs.Stat(loc.rest)
+		if err != nil {
+			return pathError("chdir", name, err)
+		}
+		if !info.IsDir() {
+			return &os.PathError{Op: "chdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		fs.dir = loc.mount.point
+		fs.wd = filepath.Clean(loc.rest)
+		if fs.wd == "." {
+			fs.wd = ""
+		}
+		return nil
+	}
+	if !loc.node.IsDir() {
+		return &os.PathError{Op: "chdir", Path: name, Err: syscall.ENOTDIR}
 	}
 
-	fs.cwd = cwd
-	fs.dir = node
+	fs.dir = loc.node
+	fs.wd = ""
 	return nil
 }
 
+// Getwd returns the absolute path of the working directory. The path is found
+// from the directory itself, so it reflects renames of the directory or its
+// parents after Chdir. Getwd fails with ENOENT if the directory was removed.
 func (fs *FileSystem) Getwd() (dir string, err error) {
-	return fs.cwd, nil
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	var elems []string
+	for node := fs.dir; node != fs.root; {
+		parent := entry(node, "..")
+		name := entryName(parent, node)
+		if name == "" {
+			return "", &os.PathError{Op: "getwd", Path: ".", Err: syscall.ENOENT}
+		}
+		elems = append(elems, name)
+		node = parent
+	}
+	path := "/"
+	for i := len(elems) - 1; i >= 0; i-- {
+		path = filepath.Join(path, elems[i])
+	}
+	return filepath.Join(path, fs.wd), nil
 }
 
 func (fs *FileSystem) TempDir() string {
@@ -118,91 +258,132 @@ func (fs *FileSystem) Create(name string) (absfs.File, error) {
 }
 
 func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
-	if name == "/" {
-		data := fs.data[int(fs.root.Ino)]
-		return &File{fs: fs, name: name, flags: flag, node: fs.root, data: data}, nil
-	}
-	if name == "." {
-		data := fs.data[int(fs.dir.Ino)]
-		return &File{fs: fs, name: name, flags: flag, node: fs.dir, data: data}, nil
-	}
-
-	wd := fs.root
-	if !filepath.IsAbs(name) {
-		wd = fs.dir
-	}
-	var exists bool
-	node, err := wd.Resolve(name)
-	if err == nil {
-		exists = true
-	}
-
-	dir, filename := filepath.Split(name)
-	dir = filepath.Clean(dir)
-	parent, err := wd.Resolve(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, err := fs.openFile(fs.dir, fs.relative(name), flag, perm, 0)
 	if err != nil {
-		return nil, err
+		return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: err}
 	}
+	return f, nil
+}
 
+// openFile opens name relative to dir, resolving it as restricted by how.
+func (fs *FileSystem) openFile(dir *inode.Inode, name string, flag int, perm os.FileMode, how int) (absfs.File, error) {
 	access := flag & absfs.O_ACCESS
 	create := flag&os.O_CREATE != 0
+	excl := create && flag&os.O_EXCL != 0
 	truncate := flag&os.O_TRUNC != 0
 
-	// error if it does not exist, and we are not allowed to create it.
-	if !exists && !create {
-		return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	// exclusive creates and O_NOFOLLOW do not follow a final symbolic link
+	loc, err := fs.walkFrom(dir, name, !excl && flag&O_NOFOLLOW == 0, how)
+	if err != nil {
+		return nil, err
+	}
+	if loc.mount != nil {
+		return loc.mount.openFile(loc.rest, flag, perm)
 	}
-	if exists {
+	node := loc.node
+	readOnly := fs.readOnlyAt(loc)
+
+	if node != nil {
 		// err if exclusive create is required
-		if create && flag&os.O_EXCL != 0 {
-			return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+		if excl {
+			return nil, syscall.EEXIST
+		}
+		if node.Mode&os.ModeSymlink != 0 {
+			return nil, syscall.ELOOP
 		}
 		if node.IsDir() {
 			if access != os.O_RDONLY || truncate {
-				return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR} // os.ErrNotExist}
+				return nil, syscall.EISDIR
 			}
 		}
 
+		if readOnly && (access != os.O_RDONLY || truncate) {
+			return nil, syscall.EROFS
+		}
+
+		var want os.FileMode
+		switch access {
+		case os.O_RDONLY:
+			want = absfs.OS_READ
+		case os.O_WRONLY:
+			want = absfs.OS_WRITE
+		case os.O_RDWR:
+			want = absfs.OS_READ | absfs.OS_WRITE
+		}
+		if !fs.access(node, want) {
+			return nil, os.ErrPermission
+		}
+
 		// if we must truncate the file
 		if truncate {
 			fs.data[int(node.Ino)] = fs.data[int(node.Ino)][:0]
+			node.Size = 0
+			fs.modified(node)
 		}
 
 	} else { // !exists
 		// error if we cannot create the file
 		if !create {
-			return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT} //os.ErrNotExist}
+			return nil, syscall.ENOENT
+		}
+		if strings.HasSuffix(name, "/") {
+			return nil, syscall.EISDIR
+		}
+		if readOnly {
+			return nil, syscall.EROFS
+		}
+
+		// error if we may not add entries to the parent directory
+		if !fs.access(loc.parent, absfs.OS_WRITE|absfs.OS_EX) {
+			return nil, os.ErrPermission
 		}
 
 		// Create write-able file
-		node = fs.ino.New(fs.Umask & perm)
-		err := parent.Link(filename, node)
+		node = fs.newInode(fs.createMode(loc.parent, perm))
+		err := fs.link(loc.parent, loc.name, node)
 		if err != nil {
-			return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: err}
+			return nil, err
 		}
-		fs.data = append(fs.data, []byte{})
+		fs.inheritACL(loc.parent, node)
 	}
 	data := fs.data[int(node.Ino)]
-
-	if !create {
-		if access == os.O_RDONLY && node.Mode&absfs.OS_ALL_R == 0 ||
-			access == os.O_WRONLY && node.Mode&absfs.OS_ALL_W == 0 ||
-			access == os.O_RDWR && node.Mode&(absfs.OS_ALL_W|absfs.OS_ALL_R) == 0 {
-			return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
-		}
-	}
+	fs.open[node.Ino]++
 	return &File{fs: fs, name: name, flags: flag, node: node, data: data}, nil
 }
 
 func (fs *FileSystem) Truncate(name string, size int64) error {
-	path := inode.Abs(fs.cwd, name)
-	child, err := fs.root.Resolve(path)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.EINVAL}
+	}
+	loc, err := fs.lookup(name, true)
 	if err != nil {
-		return err
+		return &os.PathError{Op: "truncate", Path: name, Err: err}
+	}
+	if fs.readOnlyAt(loc) {
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.EROFS}
+	}
+	if loc.mount != nil {
+		return pathError("truncate", name, loc.mount.fs.Truncate(loc.rest, size))
+	}
+	child := loc.node
+	if child.IsDir() {
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.EISDIR}
+	}
+	if !fs.access(child, absfs.OS_WRITE) {
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.EACCES}
+	}
+	if size > fs.sizeLimit() {
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.EFBIG}
 	}
 
 	i := int(child.Ino)
-	if size <= child.Size {
+	child.Size = size
+	fs.modified(child)
+	if int(size) <= len(fs.data[i]) {
 		fs.data[i] = fs.data[i][:int(size)]
 		return nil
 	}
@@ -213,265 +394,576 @@ func (fs *FileSystem) Truncate(name string, size int64) error {
 }
 
 func (fs *FileSystem) Mkdir(name string, perm os.FileMode) error {
-	wd := fs.root
-	abs := name
-	if !filepath.IsAbs(abs) {
-		abs = filepath.Join(fs.cwd, abs)
-		wd = fs.dir
-	}
-	_, err := wd.Resolve(name)
-	if err == nil {
-		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.mkdir(fs.dir, fs.relative(name), perm)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
+	return nil
+}
 
-	parent := fs.root
-	dir, filename := filepath.Split(abs)
-	dir = filepath.Clean(dir)
-	if dir != "/" {
-		parent, err = fs.root.Resolve(strings.TrimLeft(dir, "/"))
-		if err != nil {
-			return &os.PathError{Op: "mkdir", Path: dir, Err: err}
+// mkdir creates the directory name relative to dir.
+func (fs *FileSystem) mkdir(dir *inode.Inode, name string, perm os.FileMode) error {
+	loc, err := fs.walkFrom(dir, name, false, 0)
+	if err != nil {
+		return err
+	}
+	if loc.mount != nil {
+		if fs.readOnlyAt(loc) {
+			return syscall.EROFS
 		}
+		return unwrap(loc.mount.fs.Mkdir(loc.rest, perm))
+	}
+	if loc.node != nil {
+		return syscall.EEXIST
+	}
+	if fs.readOnlyAt(loc) {
+		return syscall.EROFS
+	}
+	if !fs.access(loc.parent, absfs.OS_WRITE|absfs.OS_EX) {
+		return syscall.EACCES
 	}
 
-	child := fs.ino.NewDir(fs.Umask & perm)
-	parent.Link(filename, child)
-	child.Link("..", parent)
-	fs.data = append(fs.data, []byte{})
+	child := fs.newDir(fs.createMode(loc.parent, perm))
+	err = fs.link(loc.parent, loc.name, child)
+	if err != nil {
+		return err
+	}
+	fs.link(child, "..", loc.parent)
+	fs.inheritACL(loc.parent, child)
 	return nil
 }
 
 func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
-	name = inode.Abs(fs.cwd, name)
 	path := ""
-	for _, p := range strings.Split(name, string(fs.Separator())) {
+	if filepath.IsAbs(name) {
+		path = "/"
+	}
+	for _, p := range strings.Split(name, "/") {
 		if p == "" {
-			p = "/"
+			continue
 		}
 		path = filepath.Join(path, p)
-		fs.Mkdir(path, perm)
+		info, err := fs.Stat(path)
+		if err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		err = fs.Mkdir(path, perm)
+		if err != nil {
+			// as in os.MkdirAll, a directory created meanwhile is fine
+			if info, lerr := fs.Lstat(path); lerr == nil && info.IsDir() {
+				continue
+			}
+			return err
+		}
 	}
 	return nil
 }
 
 func (fs *FileSystem) Remove(name string) (err error) {
-	wd := fs.root
-	abs := name
-	if !filepath.IsAbs(abs) {
-		abs = filepath.Join(fs.cwd, abs)
-		wd = fs.dir
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, false)
+	if err == nil {
+		err = fs.remove(loc)
 	}
-	child, err := wd.Resolve(name)
 	if err != nil {
 		return &os.PathError{Op: "remove", Path: name, Err: err}
 	}
+	return nil
+}
 
-	if child.IsDir() {
-		if len(child.Dir) > 0 {
-			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+// remove removes the file or empty directory at loc.
+func (fs *FileSystem) remove(loc location) error {
+	if loc.mount != nil {
+		if loc.rest == "." {
+			return syscall.EBUSY
+		}
+		if fs.readOnlyAt(loc) {
+			return syscall.EROFS
 		}
+		return unwrap(loc.mount.fs.Remove(loc.rest))
+	}
+	child := loc.node
+	switch {
+	case child == fs.root:
+		return syscall.EBUSY
+	case loc.name == "." || loc.name == "..":
+		return syscall.EINVAL
+	case fs.readOnlyAt(loc):
+		return syscall.EROFS
+	case !fs.access(loc.parent, absfs.OS_WRITE|absfs.OS_EX):
+		return syscall.EACCES
 	}
 
-	parent := fs.root
-	dir, filename := filepath.Split(abs)
-	dir = filepath.Clean(dir)
-	if dir != "/" {
-		parent, err = fs.root.Resolve(strings.TrimLeft(dir, "/"))
-		if err != nil {
-			return &os.PathError{Op: "remove", Path: dir, Err: err}
+	if child.IsDir() {
+		if !emptyDir(child) {
+			return syscall.ENOTEMPTY
 		}
+		return fs.rmdir(loc.parent, loc.name, child)
 	}
-	return parent.Unlink(filename)
+	return fs.unlink(loc.parent, loc.name)
 }
 
+// RemoveAll removes name and its contents the way os.RemoveAll does on Unix:
+// it removes as much as it can, and reports the first error with the same
+// operation and path as os.RemoveAll.
 func (fs *FileSystem) RemoveAll(name string) error {
-	wd := fs.root
-	abs := name
-	if !filepath.IsAbs(abs) {
-		abs = filepath.Join(fs.cwd, abs)
-		wd = fs.dir
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, false)
+	if err == syscall.ENOENT {
+		return nil
 	}
-	child, err := wd.Resolve(name)
-	if err != nil {
-		return &os.PathError{Op: "remove", Path: name, Err: err}
+	if err == nil {
+		if loc.mount != nil {
+			if loc.rest == "." {
+				return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+			}
+			if fs.readOnlyAt(loc) {
+				return &os.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+			}
+			return pathError("remove", name, loc.mount.fs.RemoveAll(loc.rest))
+		}
+		if loc.name == "." || loc.name == ".." {
+			return &os.PathError{Op: "RemoveAll", Path: name, Err: syscall.EINVAL}
+		}
+		if loc.node == fs.root {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+		}
+		if fs.readOnlyAt(loc) {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+		}
+		if fs.remove(loc) == nil {
+			return nil
+		}
 	}
 
-	parent := fs.root
-	dir, filename := filepath.Split(abs)
-	dir = filepath.Clean(dir)
-	if dir != "/" {
-		parent, err = fs.root.Resolve(strings.TrimLeft(dir, "/"))
-		if err != nil {
-			return &os.PathError{Op: "remove", Path: dir, Err: err}
+	// open the parent directory and remove the entry from it
+	dir, base := ".", strings.TrimRight(name, "/")
+	if i := strings.LastIndex(base, "/"); i >= 0 {
+		dir, base = base[:i], base[i+1:]
+		if dir == "" {
+			dir = "/"
 		}
 	}
-	child.UnlinkAll()
-	return parent.Unlink(filename)
+	parent, perr := fs.lookup(dir, true)
+	switch {
+	case perr == syscall.ENOENT:
+		return nil
+	case perr == nil && parent.mount != nil:
+		return pathError("remove", name, parent.mount.fs.RemoveAll(filepath.Join(parent.rest, base)))
+	case perr == nil && !fs.access(parent.node, absfs.OS_READ):
+		perr = syscall.EACCES
+	}
+	if perr != nil {
+		return &os.PathError{Op: "open", Path: dir, Err: perr}
+	}
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	if !parent.node.IsDir() {
+		return &os.PathError{Op: "unlinkat", Path: prefix + base, Err: syscall.ENOTDIR}
+	}
+	err = fs.removeAllFrom(parent.node, base)
+	if perr, ok := err.(*os.PathError); ok {
+		perr.Path = prefix + perr.Path
+	}
+	return err
+}
+
+// removeAllFrom removes the entry name of dir and its contents, checking
+// permissions at each step like the system calls used by os.RemoveAll. It
+// returns the first error, with a path relative to dir.
+func (fs *FileSystem) removeAllFrom(dir *inode.Inode, name string) error {
+	var node *inode.Inode
+	searchable := fs.access(dir, absfs.OS_EX)
+	if searchable {
+		node = entry(dir, name)
+		if node == nil {
+			return nil
+		}
+	}
+	writable := searchable && fs.access(dir, absfs.OS_WRITE)
+
+	// unlink the entry, which fails for directories
+	var uerr error
+	switch {
+	case !writable:
+		uerr = syscall.EACCES
+	case node.IsDir():
+		uerr = syscall.EISDIR
+	default:
+		return pathError("unlinkat", name, fs.unlink(dir, name))
+	}
+
+	// open the entry as a directory and remove its contents
+	var first error
+	switch {
+	case !searchable:
+		first = &os.PathError{Op: "openfdat", Path: name, Err: syscall.EACCES}
+	case node.Mode&os.ModeSymlink != 0:
+		return &os.PathError{Op: "openfdat", Path: name, Err: uerr}
+	case !node.IsDir():
+		return &os.PathError{Op: "unlinkat", Path: name, Err: uerr}
+	case fs.mounted(node) != nil:
+		return &os.PathError{Op: "unlinkat", Path: name, Err: syscall.EBUSY}
+	case !fs.access(node, absfs.OS_READ):
+		first = &os.PathError{Op: "openfdat", Path: name, Err: syscall.EACCES}
+	default:
+		entries := make(inode.Directory, len(node.Dir))
+		copy(entries, node.Dir)
+		for _, e := range entries {
+			if e.Name == "." || e.Name == ".." {
+				continue
+			}
+			err := fs.removeAllFrom(node, e.Name)
+			if perr, ok := err.(*os.PathError); ok {
+				perr.Path = name + "/" + perr.Path
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	// remove the directory itself
+	var err error
+	switch {
+	case !writable:
+		err = syscall.EACCES
+	case !emptyDir(node):
+		err = syscall.ENOTEMPTY
+	default:
+		err = fs.rmdir(dir, name, node)
+		if err == nil {
+			return nil
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return &os.PathError{Op: "unlinkat", Path: name, Err: err}
 }
 
 //Chtimes changes the access and modification times of the named file
 func (fs *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	var err error
-	node := fs.root
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	if fs.readOnlyAt(loc) {
+		return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EROFS}
+	}
+	if loc.mount != nil {
+		return pathError("chtimes", name, loc.mount.fs.Chtimes(loc.rest, atime, mtime))
+	}
+	fs.chtimes(loc.node, atime, mtime)
+	return nil
+}
 
-	name = inode.Abs(fs.cwd, name)
-	if name != "/" {
-		node, err = fs.root.Resolve(strings.TrimLeft(name, "/"))
-		if err != nil {
-			return err
+// Lchtimes is like Chtimes but does not follow a final symbolic link, changing
+// the times of the link itself.
+func (fs *FileSystem) Lchtimes(name string, atime time.Time, mtime time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, false)
+	if err != nil {
+		return &os.PathError{Op: "lchtimes", Path: name, Err: err}
+	}
+	if fs.readOnlyAt(loc) {
+		return &os.PathError{Op: "lchtimes", Path: name, Err: syscall.EROFS}
+	}
+	if loc.mount != nil {
+		lfs, ok := loc.mount.fs.(interface {
+			Lchtimes(name string, atime time.Time, mtime time.Time) error
+		})
+		if !ok {
+			return &os.PathError{Op: "lchtimes", Path: name, Err: syscall.EOPNOTSUPP}
 		}
+		return pathError("lchtimes", name, lfs.Lchtimes(loc.rest, atime, mtime))
 	}
+	fs.chtimes(loc.node, atime, mtime)
+	return nil
+}
 
+func (fs *FileSystem) chtimes(node *inode.Inode, atime, mtime time.Time) {
 	node.Atime = atime
 	node.Mtime = mtime
-	return nil
+	fs.changed(node)
 }
 
 //Chown changes the owner and group ids of the named file
 func (fs *FileSystem) Chown(name string, uid, gid int) error {
-	var err error
-	node := fs.root
-
-	name = inode.Abs(fs.cwd, name)
-	if name != "/" {
-		node, err = fs.root.Resolve(name)
-		if err != nil {
-			return err
-		}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	if fs.readOnlyAt(loc) {
+		return &os.PathError{Op: "chown", Path: name, Err: syscall.EROFS}
+	}
+	if loc.mount != nil {
+		return pathError("chown", name, loc.mount.fs.Chown(loc.rest, uid, gid))
 	}
-	node.Uid = uint32(uid)
-	node.Gid = uint32(gid)
+	if !fs.mayChown(loc.node, uid, gid) {
+		return &os.PathError{Op: "chown", Path: name, Err: syscall.EPERM}
+	}
+	fs.chown(loc.node, uid, gid)
 	return nil
 }
 
-//Chmod changes the mode of the named file to mode.
-func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
-	var err error
-	node := fs.root
-
-	name = inode.Abs(fs.cwd, name)
-
-	// return nil
-	if name != "/" {
-		node, err = fs.root.Resolve(strings.TrimLeft(name, "/"))
-		if err != nil {
-			return err
-		}
+// chown sets the owner and group of node. A uid or gid of -1 leaves that id
+// unchanged.
+func (fs *FileSystem) chown(node *inode.Inode, uid, gid int) {
+	if uid != -1 {
+		node.Uid = uint32(uid)
 	}
-	node.Mode = mode
-	return nil
+	if gid != -1 {
+		node.Gid = uint32(gid)
+	}
+	fs.changed(node)
 }
 
-// TODO: Avoid cyclical links
-func (fs *FileSystem) fileStat(cwd, name string) (*inode.Inode, error) {
-	name = inode.Abs(cwd, name)
-	node, err := fs.root.Resolve(strings.TrimLeft(name, "/"))
+// chmodBits are the mode bits that may be changed by Chmod.
+const chmodBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+//Chmod changes the mode of the named file to mode.
+func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, true)
 	if err != nil {
-		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
 	}
-
-	if node.Mode&os.ModeSymlink == 0 {
-		return node, nil
+	if fs.readOnlyAt(loc) {
+		return &os.PathError{Op: "chmod", Path: name, Err: syscall.EROFS}
+	}
+	if loc.mount != nil {
+		return pathError("chmod", name, loc.mount.fs.Chmod(loc.rest, mode))
 	}
-	return fs.fileStat(filepath.Dir(name), fs.symlinks[node.Ino])
+	node := loc.node
+	if !fs.owns(node) {
+		return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+	}
+	node.Mode = node.Mode&^chmodBits | mode&chmodBits
+	fs.changed(node)
+	return nil
 }
 
 func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
-	if name == "/" {
-		return &fileinfo{"/", fs.root}, nil
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	loc, err := fs.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if loc.mount != nil {
+		info, err := loc.mount.fs.Stat(loc.rest)
+		if err != nil {
+			return nil, pathError("stat", name, err)
+		}
+		return info, nil
 	}
-	node, err := fs.fileStat(fs.cwd, name)
-	return &fileinfo{filepath.Base(name), node}, err
+	return fs.fileinfo(filepath.Base(name), loc.node), nil
 }
 
 func (fs *FileSystem) Lstat(name string) (os.FileInfo, error) {
-	if name == "/" {
-		return &fileinfo{"/", fs.root}, nil
-	}
-	name = inode.Abs(fs.cwd, name)
-	node, err := fs.root.Resolve(strings.TrimLeft(name, "/"))
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	loc, err := fs.lookup(name, false)
 	if err != nil {
-		return nil, &os.PathError{Op: "remove", Path: name, Err: err}
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
 	}
-
-	return &fileinfo{filepath.Base(name), node}, nil
+	if loc.mount != nil {
+		info, err := loc.mount.lstat(loc.rest)
+		if err != nil {
+			return nil, pathError("lstat", name, err)
+		}
+		return info, nil
+	}
+	return fs.fileinfo(filepath.Base(name), loc.node), nil
 }
 
+// Lchown is like Chown but does not follow a final symbolic link, changing the
+// owner of the link itself.
 func (fs *FileSystem) Lchown(name string, uid, gid int) error {
-	if name == "/" {
-		fs.root.Uid = uint32(uid)
-		fs.root.Gid = uint32(gid)
-		return nil
-	}
-	name = inode.Abs(fs.cwd, name)
-	node, err := fs.root.Resolve(strings.TrimLeft(name, "/"))
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, false)
 	if err != nil {
-		return err
+		return &os.PathError{Op: "lchown", Path: name, Err: err}
 	}
-
-	node.Uid = uint32(uid)
-	node.Gid = uint32(gid)
+	if fs.readOnlyAt(loc) {
+		return &os.PathError{Op: "lchown", Path: name, Err: syscall.EROFS}
+	}
+	if loc.mount != nil {
+		sl, ok := loc.mount.symlinker()
+		if !ok {
+			return pathError("lchown", name, loc.mount.fs.Chown(loc.rest, uid, gid))
+		}
+		return pathError("lchown", name, sl.Lchown(loc.rest, uid, gid))
+	}
+	if !fs.mayChown(loc.node, uid, gid) {
+		return &os.PathError{Op: "lchown", Path: name, Err: syscall.EPERM}
+	}
+	fs.chown(loc.node, uid, gid)
 	return nil
 }
 
+// Readlink returns the target of the named symbolic link. It fails with EINVAL
+// if name is not a symbolic link.
 func (fs *FileSystem) Readlink(name string) (string, error) {
-	var ino uint64
-	if name == "/" {
-		ino = fs.root.Ino
-	} else {
-		node, err := fs.root.Resolve(strings.TrimLeft(name, "/"))
-		if err != nil {
-			return "", err
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	loc, err := fs.lookup(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if loc.mount != nil {
+		sl, ok := loc.mount.symlinker()
+		if !ok {
+			return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
 		}
-		ino = node.Ino
+		target, err := sl.Readlink(loc.rest)
+		return target, pathError("readlink", name, err)
 	}
-
-	return fs.symlinks[ino], nil
+	node := loc.node
+	if node.Mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return fs.symlinks[node.Ino], nil
 }
 
+// Symlink creates newname as a symbolic link to oldname. The target is stored
+// as given and need not exist; a relative target is resolved against the
+// directory containing the link each time the link is followed.
 func (fs *FileSystem) Symlink(oldname, newname string) error {
-	wd := fs.root
-	if !filepath.IsAbs(newname) {
-		wd = fs.dir
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if oldname == "" {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.ENOENT}
 	}
-	var exists bool
-	newNode, err := wd.Resolve(newname)
-	if err == nil {
-		exists = true
+	loc, err := fs.walk(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
 	}
-
-	if exists && newNode.Mode&os.ModeSymlink == 0 {
-		return &os.PathError{Op: "symlink", Path: newname, Err: syscall.EEXIST}
+	if loc.node == nil && fs.readOnlyAt(loc) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EROFS}
 	}
-	oldNode, err := wd.Resolve(oldname)
-	if err != nil {
-		return &os.PathError{Op: "symlink", Path: oldname, Err: syscall.ENOENT}
+	if loc.mount != nil {
+		sl, ok := loc.mount.symlinker()
+		if !ok {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EPERM}
+		}
+		err = sl.Symlink(oldname, loc.rest)
+		if err != nil {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: unwrap(err)}
+		}
+		return nil
+	}
+	if loc.node != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EEXIST}
+	}
+	if !fs.access(loc.parent, absfs.OS_WRITE|absfs.OS_EX) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EACCES}
 	}
 
-	if exists {
-		newNode.Mode = oldNode.Mode | os.ModeSymlink
-		fs.symlinks[newNode.Ino] = oldname
-		return nil
+	node := fs.newInode(os.ModeSymlink | 0777)
+	node.Size = int64(len(oldname))
+	err = fs.link(loc.parent, loc.name, node)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
 	}
+	fs.symlinks[node.Ino] = oldname
+	return nil
+}
 
-	dir, filename := filepath.Split(newname)
-	dir = filepath.Clean(dir)
-	parent, err := wd.Resolve(dir)
+// Link creates newname as a hard link to the file oldname. A final symbolic
+// link in oldname is not followed, as by link(2) on Linux. Directories cannot
+// be linked and fail with EPERM, and linking across mounted filesystems fails
+// with EXDEV.
+func (fs *FileSystem) Link(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.hardlink(oldname, newname)
 	if err != nil {
-		return err
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
 	}
+	return nil
+}
 
-	newNode = fs.ino.New(oldNode.Mode | os.ModeSymlink)
+// linker is implemented by filesystems supporting hard links.
+type linker interface {
+	Link(oldname, newname string) error
+}
 
-	err = parent.Link(filename, newNode)
+func (fs *FileSystem) hardlink(oldname, newname string) error {
+	old, err := fs.lookup(oldname, false)
 	if err != nil {
-		return &os.PathError{Op: "symlink", Path: newname, Err: err}
+		return err
 	}
-	fs.symlinks[newNode.Ino] = oldname
-	return nil
+	loc, err := fs.walk(newname, false)
+	if err != nil {
+		return err
+	}
+	if old.mount != nil || loc.mount != nil {
+		if old.mount != loc.mount {
+			return syscall.EXDEV
+		}
+		l, ok := old.mount.fs.(linker)
+		switch {
+		case !ok:
+			return syscall.EPERM
+		case fs.readOnlyAt(loc):
+			return syscall.EROFS
+		}
+		return unwrap(l.Link(old.rest, loc.rest))
+	}
+
+	switch {
+	case loc.node != nil:
+		return syscall.EEXIST
+	case old.node.IsDir():
+		return syscall.EPERM
+	case fs.readOnlyAt(loc):
+		return syscall.EROFS
+	case !fs.access(loc.parent, absfs.OS_WRITE|absfs.OS_EX):
+		return syscall.EACCES
+	}
+	return fs.link(loc.parent, loc.name, old.node)
 }
 
 func (fs *FileSystem) Walk(name string, fn pathfilepath.WalkFunc) error {
+	return fs.WalkWithOptions(name, WalkOptions{}, fn)
+}
+
+// WalkOptions configures WalkWithOptions.
+type WalkOptions struct {
+	// OneFileSystem skips the contents of directories on a different
+	// filesystem than name, like the -xdev option of find(1). The mount
+	// points themselves are still visited.
+	OneFileSystem bool
+}
+
+// WalkWithOptions is like Walk, configured by opts. Walk descends into
+// mounted filesystems. As in filepath.Walk, symbolic links are reported as
+// such and not followed, so dangling and cyclic links do not stop the walk.
+func (fs *FileSystem) WalkWithOptions(name string, opts WalkOptions, fn pathfilepath.WalkFunc) error {
+	var device *mount
+	if opts.OneFileSystem {
+		loc, err := fs.lookup(name, true)
+		if err != nil {
+			return &os.PathError{Op: "walk", Path: name, Err: err}
+		}
+		device = loc.mount
+	}
+
 	var stack []string
 	push := func(path string) {
 		stack = append(stack, path)
@@ -485,12 +977,21 @@ func (fs *FileSystem) Walk(name string, fn pathfilepath.WalkFunc) error {
 	push(name)
 	for len(stack) > 0 {
 		path := pop()
-		info, err := fs.Stat(path)
+		info, err := fs.Lstat(path)
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
+		descend := info.IsDir()
+		if descend && opts.OneFileSystem {
+			loc, err := fs.lookup(path, true)
+			if err != nil {
+				return &os.PathError{Op: "walk", Path: path, Err: err}
+			}
+			descend = loc.mount == device
+		}
+
+		if descend {
 			f, err := fs.Open(path)
 			if err != nil {
 				return err
@@ -2,18 +2,22 @@ package memfs_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/absfs/absfs"
 	"github.com/absfs/fstesting"
 	"github.com/absfs/memfs"
+	"github.com/absfs/osfs"
 	"github.com/absfs/osfs/fastwalk"
 )
 
@@ -205,6 +209,14 @@ func TestMemFS(t *testing.T) {
 	}
 	defer fs.RemoveAll(fs.TempDir())
 
+	// the results are compared with those of the os, so the tests run with the
+	// credentials of the process in a directory it owns
+	err = fs.Chown(testdir, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.ProcessCredentials()
+
 	cwd, err := fs.Getwd()
 	if cwd != "/" {
 		t.Fatalf("incorrect cwd %q", cwd)
@@ -378,3 +390,277 @@ func TestOpenWrite(t *testing.T) {
 	}
 
 }
+
+func TestFollowMetadata(t *testing.T) {
+	mfs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ofs, err := osfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	// observe runs the same sequence of operations on a filesystem rooted at
+	// dir and reports what it saw, so memfs can be compared against the os.
+	observe := func(fs absfs.SymlinkFileSystem, dir string) []string {
+		var seen []string
+		report := func(format string, a ...interface{}) {
+			seen = append(seen, fmt.Sprintf(format, a...))
+		}
+		path := func(name string) string {
+			return dir + "/" + name
+		}
+
+		f, err := fs.OpenFile(path("target"), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		// osfs makes relative targets absolute against its working directory,
+		// so both filesystems are given absolute targets.
+		err = fs.Symlink(path("target"), path("link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = fs.Symlink(path("missing"), path("dangling"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		report("chmod: %v", fs.Chmod(path("link"), 0600))
+		report("chtimes: %v", fs.Chtimes(path("link"), mtime, mtime))
+		report("chown: %v", fs.Chown(path("link"), -1, -1))
+
+		info, err := fs.Stat(path("target"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report("target: %s %s", info.Mode(), info.ModTime().UTC())
+		info, err = fs.Lstat(path("link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report("link: %s %t", info.Mode().Type(), info.ModTime().Equal(mtime))
+
+		err = fs.Chmod(path("dangling"), 0600)
+		report("dangling chmod: %t", os.IsNotExist(err))
+		err = fs.Chtimes(path("dangling"), mtime, mtime)
+		report("dangling chtimes: %t", os.IsNotExist(err))
+		return seen
+	}
+
+	want := observe(ofs, t.TempDir())
+	got := observe(mfs, "")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("memfs and os differ\nmemfs:\n%s\nos:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The l-variants change the link and leave the target alone.
+	stat := func(name string, follow bool) *memfs.Stat {
+		t.Helper()
+		stat := mfs.Stat
+		if !follow {
+			stat = mfs.Lstat
+		}
+		info, err := stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := memfs.StatOf(info)
+		return s
+	}
+	ltime := mtime.Add(time.Hour)
+	err = mfs.Lchtimes("/link", ltime, ltime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stat("/link", false); !s.Mtime.Equal(ltime) || !s.Atime.Equal(ltime) {
+		t.Errorf("link times not changed: %s %s", s.Atime, s.Mtime)
+	}
+	if s := stat("/link", true); !s.Mtime.Equal(mtime) {
+		t.Errorf("target times changed: %s", s.Mtime)
+	}
+	err = mfs.Lchtimes("/dangling", ltime, ltime)
+	if err != nil {
+		t.Errorf("lchtimes on a dangling link: %v", err)
+	}
+
+	err = mfs.Lchown("/link", 1234, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stat("/link", false); s.Uid != 1234 {
+		t.Errorf("link owner not changed: %d", s.Uid)
+	}
+	if s := stat("/link", true); s.Uid == 1234 {
+		t.Errorf("target owner changed")
+	}
+	err = mfs.Chown("/link", 4321, 8765)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stat("/link", true); s.Uid != 4321 || s.Gid != 8765 {
+		t.Errorf("target owner not changed: %d:%d", s.Uid, s.Gid)
+	}
+	if s := stat("/link", false); s.Uid != 1234 {
+		t.Errorf("link owner changed: %d", s.Uid)
+	}
+}
+
+func TestNotOwner(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Uid, fs.Gid = 0, 0
+	writeFile(t, fs, "/root", "")
+	writeFile(t, fs, "/mine", "")
+	if err := fs.Chmod("/root", 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chown("/mine", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	user := fs.View()
+	user.Uid, user.Gid, user.Groups = 1000, 1000, []int{2000}
+	for _, tc := range []struct {
+		op string
+		fn func() error
+	}{
+		{"chmod", func() error { return user.Chmod("/root", 0777) }},
+		{"chown", func() error { return user.Chown("/root", 1000, -1) }},
+		{"lchown", func() error { return user.Lchown("/root", -1, 1000) }},
+		{"chown", func() error { return user.Chown("/mine", 1001, -1) }},
+		{"chown", func() error { return user.Chown("/mine", -1, 3000) }},
+	} {
+		err := tc.fn()
+		var perr *os.PathError
+		if !errors.As(err, &perr) || perr.Op != tc.op || !errors.Is(err, syscall.EPERM) {
+			t.Errorf("%s as non-owner: expected EPERM, got %v", tc.op, err)
+		}
+	}
+	info, err := fs.Stat("/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := memfs.StatOf(info); info.Mode() != 0666 || st.Uid != 0 || st.Gid != 0 {
+		t.Errorf("changed by non-owner: %s %d:%d", info.Mode(), st.Uid, st.Gid)
+	}
+
+	// the owner may change the mode and the group to one of its groups
+	if err := user.Chmod("/mine", 0600); err != nil {
+		t.Error(err)
+	}
+	if err := user.Chown("/mine", 1000, 2000); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Uid != 0 || fs.Gid != 0 || fs.Groups != nil {
+		t.Errorf("default credentials %d:%d %v", fs.Uid, fs.Gid, fs.Groups)
+	}
+	info, err := fs.Stat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := memfs.StatOf(info); st.Uid != 0 || st.Gid != 0 {
+		t.Errorf("root directory owned by %d:%d", st.Uid, st.Gid)
+	}
+
+	fs.ProcessCredentials()
+	if uid := os.Getuid(); uid >= 0 && (fs.Uid != uid || fs.Gid != os.Getgid()) {
+		t.Errorf("process credentials %d:%d, want %d:%d", fs.Uid, fs.Gid, uid, os.Getgid())
+	}
+}
+
+func TestRemoveAllPermission(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll("/d/sub/x", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/d/f", "")
+	if err := fs.Chown("/d", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chmod("/d/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// like os.RemoveAll, the accessible entries are removed and the first
+	// error is reported with the system call that failed
+	user := fs.View()
+	user.Uid, user.Gid = 1000, 1000
+	err = user.RemoveAll("/d")
+	var perr *os.PathError
+	if !errors.As(err, &perr) || perr.Op != "openfdat" || perr.Path != "/d/sub" || !errors.Is(err, syscall.EACCES) {
+		t.Errorf("expected openfdat /d/sub: permission denied, got %v", err)
+	}
+	if _, err := fs.Lstat("/d/f"); !os.IsNotExist(err) {
+		t.Errorf("accessible file not removed: %v", err)
+	}
+	if _, err := fs.Lstat("/d/sub/x"); err != nil {
+		t.Errorf("inaccessible directory changed: %v", err)
+	}
+}
+
+func TestFileSizeLimit(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/file", "content")
+	f, err := fs.OpenFile("/file", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// sizes past the limit fail instead of allocating
+	for name, fn := range map[string]func() error{
+		"writeat": func() error {
+			_, err := f.WriteAt([]byte("x"), 1<<62)
+			return err
+		},
+		"seek and write": func() error {
+			if _, err := f.Seek(math.MaxInt64, io.SeekStart); err != nil {
+				return err
+			}
+			_, err := f.Write([]byte("x"))
+			return err
+		},
+		"truncate":      func() error { return fs.Truncate("/file", 1<<62) },
+		"file truncate": func() error { return f.Truncate(1 << 62) },
+	} {
+		if err := fn(); !errors.Is(err, syscall.EFBIG) {
+			t.Errorf("%s: expected EFBIG, got %v", name, err)
+		}
+	}
+
+	// a write crossing the limit is cut short
+	fs.FileSizeLimit = 10
+	f2, err := fs.OpenFile("/file", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	if n, err := f2.Write([]byte("12345")); n != 3 || !errors.Is(err, syscall.EFBIG) {
+		t.Errorf("write across the limit: %d, %v", n, err)
+	}
+	if err := f2.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/file"); s != "content123" {
+		t.Errorf("wrong content %q", s)
+	}
+}
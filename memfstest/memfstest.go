@@ -0,0 +1,245 @@
+// Package memfstest provides test assertions about the files of an
+// absfs.FileSystem, such as a memfs FileSystem. Each assertion reports a
+// failure with t.Errorf, including a unified diff where contents differ, and
+// returns whether it passed.
+package memfstest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+// symlinkPrefix marks the values of a tree map that describe symbolic links.
+const symlinkPrefix = "\x00symlink\x00"
+
+// Symlink returns the value describing a symbolic link to target in the map
+// passed to AssertTree.
+func Symlink(target string) string {
+	return symlinkPrefix + target
+}
+
+// AssertFileContent checks that the named file exists and contains want.
+func AssertFileContent(t testing.TB, fs absfs.FileSystem, name, want string) bool {
+	t.Helper()
+	data, err := readFile(fs, name)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return false
+	}
+	if data != want {
+		t.Errorf("%s: wrong content:\n%s", name, contentDiff(name, want, data))
+		return false
+	}
+	return true
+}
+
+// AssertMode checks the mode of the named file, including its type bits. A
+// symbolic link is followed, as by Stat.
+func AssertMode(t testing.TB, fs absfs.FileSystem, name string, want os.FileMode) bool {
+	t.Helper()
+	info, err := fs.Stat(name)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return false
+	}
+	if info.Mode() != want {
+		t.Errorf("%s: mode is %s, want %s", name, info.Mode(), want)
+		return false
+	}
+	return true
+}
+
+// AssertSymlink checks that name is a symbolic link to target.
+func AssertSymlink(t testing.TB, fs absfs.FileSystem, name, target string) bool {
+	t.Helper()
+	sl, ok := fs.(absfs.SymLinker)
+	if !ok {
+		t.Errorf("%s: %T does not support symbolic links", name, fs)
+		return false
+	}
+	got, err := sl.Readlink(name)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return false
+	}
+	if got != target {
+		t.Errorf("%s: link target is %q, want %q", name, got, target)
+		return false
+	}
+	return true
+}
+
+// AssertNotExists checks that name does not exist. A dangling symbolic link
+// exists.
+func AssertNotExists(t testing.TB, fs absfs.FileSystem, name string) bool {
+	t.Helper()
+	info, err := lstat(fs, name)
+	switch {
+	case err == nil:
+		t.Errorf("%s: exists with mode %s", name, info.Mode())
+		return false
+	case !os.IsNotExist(err):
+		t.Errorf("%s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// AssertTree checks that the tree rooted at the directory root contains
+// exactly the files described by want. The keys of want are slash separated
+// paths relative to root. Keys ending in a slash name directories and have
+// empty values; directories containing other listed files need not be listed.
+// The value of a regular file is its content, and the value of a symbolic link
+// is returned by Symlink. Modes, owners and times are not compared.
+func AssertTree(t testing.TB, fs absfs.FileSystem, root string, want map[string]string) bool {
+	t.Helper()
+	got, err := tree(fs, root)
+	if err != nil {
+		t.Errorf("%s: %v", root, err)
+		return false
+	}
+	expected := make(map[string]string, len(want))
+	for name, value := range want {
+		name = strings.TrimPrefix(name, "/")
+		expected[name] = value
+		for dir := path.Dir(strings.TrimSuffix(name, "/")); dir != "."; dir = path.Dir(dir) {
+			expected[dir+"/"] = ""
+		}
+	}
+
+	a, b := render(expected), render(got)
+	if a != b {
+		t.Errorf("%s: wrong tree:\n%s", root, memfs.UnifiedDiff("tree", []byte(a), []byte(b)))
+		return false
+	}
+	return true
+}
+
+// AssertTreeTxtar is like AssertTree with the expected files given as a txtar
+// archive in the format read by memfs.FromTxtar, which can also describe empty
+// directories and symbolic links. Modes in the archive are not compared.
+func AssertTreeTxtar(t testing.TB, fs absfs.FileSystem, root, archive string) bool {
+	t.Helper()
+	expected, err := memfs.FromTxtar([]byte(archive))
+	if err != nil {
+		t.Errorf("invalid archive: %v", err)
+		return false
+	}
+	want, err := tree(expected, "/")
+	if err != nil {
+		t.Errorf("invalid archive: %v", err)
+		return false
+	}
+	return AssertTree(t, fs, root, want)
+}
+
+// tree returns the files below root in the form taken by AssertTree, with every
+// directory listed.
+func tree(fs absfs.FileSystem, root string) (map[string]string, error) {
+	files := make(map[string]string)
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		f, err := fs.Open(path.Join(root, rel))
+		if err != nil {
+			return err
+		}
+		infos, err := f.Readdir(-1)
+		f.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for _, info := range infos {
+			if info.Name() == "." || info.Name() == ".." {
+				continue
+			}
+			name := path.Join(rel, info.Name())
+			mode := info.Mode()
+			switch {
+			case mode.IsDir():
+				files[name+"/"] = ""
+				err = walk(name)
+			case mode&os.ModeSymlink != 0:
+				sl, ok := fs.(absfs.SymLinker)
+				if !ok {
+					return fmt.Errorf("%s: %T does not support symbolic links", name, fs)
+				}
+				var target string
+				target, err = sl.Readlink(path.Join(root, name))
+				files[name] = Symlink(target)
+			case mode.IsRegular():
+				files[name], err = readFile(fs, path.Join(root, name))
+			default:
+				err = fmt.Errorf("%s: unsupported file type %s", name, mode.Type())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return files, walk("")
+}
+
+// render formats a tree map as text for diffing, one file per section with a
+// txtar style header.
+func render(files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		value := files[name]
+		if target, ok := strings.CutPrefix(value, symlinkPrefix); ok {
+			fmt.Fprintf(&b, "-- %s -> %s --\n", name, target)
+			continue
+		}
+		fmt.Fprintf(&b, "-- %s --\n", name)
+		b.WriteString(value)
+		if value != "" && !strings.HasSuffix(value, "\n") {
+			b.WriteString("\n\\ no newline at end\n")
+		}
+	}
+	return b.String()
+}
+
+// contentDiff describes the difference between the expected and actual
+// contents of name.
+func contentDiff(name, want, got string) string {
+	if isText(want) && isText(got) {
+		return memfs.UnifiedDiff(name, []byte(want), []byte(got))
+	}
+	return fmt.Sprintf("got %q\nwant %q", got, want)
+}
+
+func isText(s string) bool {
+	return !strings.ContainsRune(s, 0) && strings.ToValidUTF8(s, "") == s
+}
+
+func readFile(fs absfs.FileSystem, name string) (string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return string(data), err
+}
+
+// lstat is Lstat in fs, or Stat if fs does not support symbolic links.
+func lstat(fs absfs.FileSystem, name string) (os.FileInfo, error) {
+	if sl, ok := fs.(absfs.SymLinker); ok {
+		return sl.Lstat(name)
+	}
+	return fs.Stat(name)
+}
@@ -0,0 +1,132 @@
+package memfstest_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+	"github.com/absfs/memfs/memfstest"
+)
+
+// recorder is a testing.TB that records failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// noSymlinks hides the symbolic link methods of a file system that still
+// reports symbolic links.
+type noSymlinks struct {
+	absfs.FileSystem
+}
+
+func newFS(t *testing.T) *memfs.FileSystem {
+	t.Helper()
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/root/dir/empty", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/root/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("one\ntwo\n"))
+	f.Close()
+	err = fs.Symlink("dir/file", "/root/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestAssertions(t *testing.T) {
+	fs := newFS(t)
+	r := &recorder{TB: t}
+
+	passes := []bool{
+		memfstest.AssertFileContent(r, fs, "/root/dir/file", "one\ntwo\n"),
+		memfstest.AssertFileContent(r, fs, "/root/link", "one\ntwo\n"),
+		memfstest.AssertMode(r, fs, "/root/dir", os.ModeDir|0755),
+		memfstest.AssertMode(r, fs, "/root/dir/file", 0644),
+		memfstest.AssertSymlink(r, fs, "/root/link", "dir/file"),
+		memfstest.AssertNotExists(r, fs, "/root/missing"),
+		memfstest.AssertTree(r, fs, "/root", map[string]string{
+			"dir/empty/": "",
+			"dir/file":   "one\ntwo\n",
+			"link":       memfstest.Symlink("dir/file"),
+		}),
+		memfstest.AssertTreeTxtar(r, fs, "/root", `
+Files below /root.
+-- dir/empty/ --
+-- dir/file --
+one
+two
+-- link -> dir/file --
+`[1:]),
+	}
+	for i, ok := range passes {
+		if !ok {
+			t.Errorf("assertion %d failed", i)
+		}
+	}
+	if len(r.errors) != 0 {
+		t.Errorf("unexpected failures %q", r.errors)
+	}
+}
+
+func TestAssertionFailures(t *testing.T) {
+	fs := newFS(t)
+
+	tests := []struct {
+		name   string
+		assert func(t testing.TB) bool
+		want   string
+	}{
+		{"content", func(t testing.TB) bool {
+			return memfstest.AssertFileContent(t, fs, "/root/dir/file", "one\nthree\n")
+		}, "/root/dir/file: wrong content:\n--- a/root/dir/file\n+++ b/root/dir/file\n@@ -1,2 +1,2 @@\n one\n-three\n+two\n"},
+		{"missing", func(t testing.TB) bool {
+			return memfstest.AssertFileContent(t, fs, "/root/missing", "")
+		}, "/root/missing: open /root/missing: no such file or directory"},
+		{"mode", func(t testing.TB) bool {
+			return memfstest.AssertMode(t, fs, "/root/dir/file", 0600)
+		}, "/root/dir/file: mode is -rw-r--r--, want -rw-------"},
+		{"symlink", func(t testing.TB) bool {
+			return memfstest.AssertSymlink(t, fs, "/root/link", "elsewhere")
+		}, `/root/link: link target is "dir/file", want "elsewhere"`},
+		{"exists", func(t testing.TB) bool {
+			return memfstest.AssertNotExists(t, fs, "/root/link")
+		}, "/root/link: exists with mode Lrwxrwxrwx"},
+		{"tree", func(t testing.TB) bool {
+			return memfstest.AssertTree(t, fs, "/root", map[string]string{
+				"dir/file":  "one\ntwo\n",
+				"dir/extra": "extra",
+				"link":      memfstest.Symlink("dir/file"),
+			})
+		}, "/root: wrong tree:\n--- a/tree\n+++ b/tree\n@@ -1,7 +1,5 @@\n -- dir/ --\n--- dir/extra --\n-extra\n-\\ no newline at end\n+-- dir/empty/ --\n -- dir/file --\n one\n two\n"},
+		{"symlink support", func(t testing.TB) bool {
+			return memfstest.AssertTree(t, noSymlinks{fs}, "/root", nil)
+		}, "/root: link: memfstest_test.noSymlinks does not support symbolic links"},
+	}
+	for _, test := range tests {
+		r := &recorder{TB: t}
+		if test.assert(r) {
+			t.Errorf("%s: assertion passed", test.name)
+		}
+		if len(r.errors) != 1 || r.errors[0] != test.want {
+			t.Errorf("%s: wrong failure %q, want %q", test.name, r.errors, test.want)
+		}
+	}
+}
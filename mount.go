@@ -0,0 +1,193 @@
+package memfs
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/inode"
+)
+
+// MountOptions configures a filesystem mounted with Mount. The zero value
+// selects the defaults.
+type MountOptions struct {
+	// ReadOnly makes operations that would modify files in the mount fail
+	// with EROFS.
+	ReadOnly bool
+}
+
+// mount is a filesystem mounted on a directory of the tree.
+type mount struct {
+	point *inode.Inode // directory the filesystem is mounted on.
+	fs    absfs.FileSystem
+	opts  MountOptions
+}
+
+// Mount mounts mfs on the directory name, hiding the contents of the directory
+// until Unmount. Paths through name continue in mfs for every operation, and
+// ".." at the root of mfs returns to the directory containing name. The mount
+// is shared by all FileSystems derived from fs with View or Sub. Mounting on a
+// directory that already has a filesystem mounted on it fails with EBUSY, and
+// mounting fs itself or a FileSystem derived from it fails with EINVAL.
+//
+// Paths inside the mount are passed to mfs relative to its working directory,
+// which acts as the root of the mount, so mounting an osfs FileSystem that was
+// changed into a directory exposes just that directory. Symbolic links inside
+// mfs are resolved by mfs. Extended attributes and ACLs are not supported
+// inside mounts.
+func (fs *FileSystem) Mount(name string, mfs absfs.FileSystem, opts MountOptions) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, true)
+	if err == nil && mfs == nil {
+		err = syscall.EINVAL
+	}
+	if inner, ok := mfs.(*FileSystem); err == nil && ok && inner != nil && inner.tree == fs.tree {
+		// operations through the mount would take the lock of the tree twice
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return &os.PathError{Op: "mount", Path: name, Err: err}
+	}
+	if loc.mount != nil {
+		// a memfs mounted inside fs can hold mounts of its own
+		if inner, ok := loc.mount.fs.(*FileSystem); ok && loc.rest != "." {
+			return pathError("mount", name, inner.Mount(loc.rest, mfs, opts))
+		}
+		return &os.PathError{Op: "mount", Path: name, Err: syscall.EBUSY}
+	}
+	switch {
+	case !loc.node.IsDir():
+		return &os.PathError{Op: "mount", Path: name, Err: syscall.ENOTDIR}
+	case loc.node == fs.root:
+		return &os.PathError{Op: "mount", Path: name, Err: syscall.EBUSY}
+	}
+
+	fs.mounts[loc.node.Ino] = &mount{point: loc.node, fs: mfs, opts: opts}
+	return nil
+}
+
+// Unmount removes the filesystem mounted on the directory name, uncovering
+// its contents. Working directories inside the mount are not changed and
+// afterwards refer to the uncovered directory.
+func (fs *FileSystem) Unmount(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	loc, err := fs.lookup(name, true)
+	if err == nil && loc.mount == nil {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return &os.PathError{Op: "unmount", Path: name, Err: err}
+	}
+	if loc.rest != "." {
+		if inner, ok := loc.mount.fs.(*FileSystem); ok {
+			return pathError("unmount", name, inner.Unmount(loc.rest))
+		}
+		return &os.PathError{Op: "unmount", Path: name, Err: syscall.EINVAL}
+	}
+	delete(fs.mounts, loc.mount.point.Ino)
+	return nil
+}
+
+// mounted returns the mount on dir, or nil if nothing is mounted on it.
+func (fs *FileSystem) mounted(dir *inode.Inode) *mount {
+	if len(fs.mounts) == 0 {
+		return nil
+	}
+	return fs.mounts[dir.Ino]
+}
+
+// enter resolves the path elements elems, relative to the root of m, to a
+// location in m, the path relative to the working directory of the mounted
+// filesystem. ".." is applied lexically; if it leaves the root of m, enter
+// returns false and the elements that remain to be resolved from the
+// directory containing the mount point.
+func (m *mount) enter(elems []string, trailing bool) (location, []string, bool) {
+	var inner []string
+	for i, elem := range elems {
+		if elem != ".." {
+			inner = append(inner, elem)
+			continue
+		}
+		if len(inner) == 0 {
+			return location{}, elems[i+1:], false
+		}
+		inner = inner[:len(inner)-1]
+	}
+	if len(inner) == 0 {
+		return location{mount: m, rest: "."}, nil, true
+	}
+	rest := strings.Join(inner, "/")
+	if trailing {
+		rest += "/"
+	}
+	return location{mount: m, rest: rest}, nil, true
+}
+
+// symlinker returns the mounted filesystem as an absfs.SymLinker, or false if
+// it does not support symbolic links.
+func (m *mount) symlinker() (absfs.SymLinker, bool) {
+	sl, ok := m.fs.(absfs.SymLinker)
+	return sl, ok
+}
+
+// openFile opens name in the mounted filesystem. Files in a read-only mount
+// may only be opened for reading.
+func (m *mount) openFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if m.opts.ReadOnly {
+		if flag&absfs.O_ACCESS != os.O_RDONLY || flag&os.O_TRUNC != 0 {
+			return nil, syscall.EROFS
+		}
+		if flag&os.O_CREATE != 0 {
+			// only opening an existing file is allowed
+			f, err := m.fs.OpenFile(name, flag&^(os.O_CREATE|os.O_EXCL), perm)
+			switch {
+			case os.IsNotExist(err):
+				return nil, syscall.EROFS
+			case err != nil:
+				return nil, unwrap(err)
+			case flag&os.O_EXCL != 0:
+				f.Close()
+				return nil, syscall.EEXIST
+			}
+			return f, nil
+		}
+	}
+	f, err := m.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, unwrap(err)
+	}
+	return f, nil
+}
+
+// lstat is Lstat in the mounted filesystem, or Stat if it does not support
+// symbolic links.
+func (m *mount) lstat(name string) (os.FileInfo, error) {
+	if sl, ok := m.symlinker(); ok {
+		return sl.Lstat(name)
+	}
+	return m.fs.Stat(name)
+}
+
+// unwrap returns the error wrapped by a *os.PathError or *os.LinkError from a
+// mounted filesystem, so that it can be reported against the path used in fs.
+func unwrap(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Err
+	case *os.LinkError:
+		return e.Err
+	}
+	return err
+}
+
+// pathError returns err from a mounted filesystem as a *os.PathError for name,
+// or nil if err is nil.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: name, Err: unwrap(err)}
+}
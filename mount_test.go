@@ -0,0 +1,242 @@
+package memfs_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+	"github.com/absfs/osfs"
+)
+
+func TestMount(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/mnt", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/mnt/covered", "covered")
+	writeFile(t, fs, "/outer", "outer")
+	writeFile(t, inner, "/file", "inner")
+
+	err = fs.Mount("/mnt", inner, memfs.MountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, fs, "/mnt/file"); s != "inner" {
+		t.Errorf("wrong content %q", s)
+	}
+	if _, err := fs.Stat("/mnt/covered"); !os.IsNotExist(err) {
+		t.Errorf("covered file visible: %v", err)
+	}
+	writeFile(t, fs, "/mnt/new", "from outer")
+	if s := readFile(t, inner, "/new"); s != "from outer" {
+		t.Errorf("wrong content %q", s)
+	}
+	if s := readFile(t, fs, "/mnt/../outer"); s != "outer" {
+		t.Errorf("wrong content %q", s)
+	}
+
+	// Relative paths and the working directory cross the mount point.
+	err = fs.Mkdir("/mnt/dir", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Chdir("/mnt/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, _ := fs.Getwd(); dir != "/mnt/dir" {
+		t.Errorf("wrong working directory %q", dir)
+	}
+	writeFile(t, fs, "relative", "relative")
+	if s := readFile(t, inner, "/dir/relative"); s != "relative" {
+		t.Errorf("wrong content %q", s)
+	}
+	if s := readFile(t, fs, "../../outer"); s != "outer" {
+		t.Errorf("wrong content %q", s)
+	}
+	err = fs.Chdir("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, _ := fs.Getwd(); dir != "/" {
+		t.Errorf("wrong working directory %q", dir)
+	}
+
+	// Symbolic links in the outer filesystem lead into the mount.
+	err = fs.Symlink("/mnt/dir", "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/link/relative"); s != "relative" {
+		t.Errorf("wrong content %q", s)
+	}
+
+	err = fs.Rename("/mnt/file", "/file")
+	if !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("expected EXDEV, got %v", err)
+	}
+	err = fs.Rename("/outer", "/mnt/outer")
+	if !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("expected EXDEV, got %v", err)
+	}
+	err = fs.Rename("/mnt/file", "/mnt/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, inner, "/dir/file"); s != "inner" {
+		t.Errorf("wrong content %q", s)
+	}
+
+	for _, err := range []error{fs.Remove("/mnt"), fs.RemoveAll("/mnt"), fs.Rename("/mnt", "/x")} {
+		if !errors.Is(err, syscall.EBUSY) {
+			t.Errorf("expected EBUSY, got %v", err)
+		}
+	}
+	if err := fs.Mount("/mnt", inner, memfs.MountOptions{}); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected EBUSY, got %v", err)
+	}
+	if err := fs.Mount("/outer", inner, memfs.MountOptions{}); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+	if err := fs.Mount("/", inner, memfs.MountOptions{}); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected EBUSY, got %v", err)
+	}
+	if err := fs.Setxattr("/mnt/dir/file", "user.test", nil, 0); !errors.Is(err, syscall.EOPNOTSUPP) {
+		t.Errorf("expected EOPNOTSUPP, got %v", err)
+	}
+
+	err = fs.Unmount("/mnt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mount("/mnt", fs.View(), memfs.MountOptions{}); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("expected EINVAL, got %v", err)
+	}
+	if s := readFile(t, fs, "/mnt/covered"); s != "covered" {
+		t.Errorf("wrong content %q", s)
+	}
+	if err := fs.Unmount("/mnt"); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("expected EINVAL, got %v", err)
+	}
+}
+
+func TestMountWalk(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/a/mnt", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/a/file", "")
+	err = inner.Mkdir("/dir", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, inner, "/dir/file", "")
+	err = fs.Mount("/a/mnt", inner, memfs.MountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(opts memfs.WalkOptions) []string {
+		var paths []string
+		err := fs.WalkWithOptions("/a", opts, func(path string, info os.FileInfo, err error) error {
+			paths = append(paths, path)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	all := walk(memfs.WalkOptions{})
+	want := []string{"/a", "/a/file", "/a/mnt", "/a/mnt/dir", "/a/mnt/dir/file"}
+	if !equalStrings(all, want) {
+		t.Errorf("wrong paths\n%q\nwant\n%q", all, want)
+	}
+	xdev := walk(memfs.WalkOptions{OneFileSystem: true})
+	want = []string{"/a", "/a/file", "/a/mnt"}
+	if !equalStrings(xdev, want) {
+		t.Errorf("wrong paths with OneFileSystem\n%q\nwant\n%q", xdev, want)
+	}
+}
+
+func TestMountOS(t *testing.T) {
+	dir := t.TempDir()
+	ofs, err := osfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ofs.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "host"), []byte("host"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/os", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mount("/os", ofs, memfs.MountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, fs, "/os/host"); s != "host" {
+		t.Errorf("wrong content %q", s)
+	}
+	writeFile(t, fs, "/os/written", "written")
+	data, err := os.ReadFile(filepath.Join(dir, "written"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "written" {
+		t.Errorf("wrong content on disk %q", data)
+	}
+	_, err = fs.Stat("/os/missing")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if perr, ok := err.(*os.PathError); !ok || perr.Path != "/os/missing" {
+		t.Errorf("error not reported against the memfs path: %v", err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,1127 @@
+package memfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// opaqueXattr marks a directory of the upper layer of an OverlayFS as opaque,
+// hiding the directory of the same path in the lower layer. The name and value
+// match Linux overlayfs.
+const opaqueXattr = "trusted.overlay.opaque"
+
+// OverlayFS is a union of a read-only lower filesystem and a writable memfs
+// upper layer, modeled after Linux overlayfs. See Overlay.
+type OverlayFS struct {
+	upper *FileSystem
+	priv  *FileSystem // view of upper with root credentials, for copy-up and lookups.
+	lower absfs.FileSystem
+	cwd   string // absolute path of the working directory.
+}
+
+// Overlay returns an OverlayFS with lower as its lower layer and a new, empty
+// FileSystem as its upper layer. Files that have not been changed are read
+// from lower. The first change to a file of lower copies it, and the
+// directories containing it, up into the upper layer, and only the copy is
+// changed; lower is never modified. Removing a file of lower leaves a whiteout
+// in the upper layer, and a directory created in place of a removed one is
+// made opaque, hiding the contents of the lower directory. Directories list
+// the entries of both layers.
+//
+// The upper layer uses the representation of overlayfs: whiteouts are
+// character devices with device number 0 and opaque directories have the
+// extended attribute trusted.overlay.opaque set to "y". Copies keep the mode
+// and modification time of the original, and its owner if lower is a memfs
+// FileSystem. Changes lists the differences between the two layers.
+//
+// As with Mount, paths are passed to lower relative to its working directory,
+// which acts as the root of the overlay. Symbolic links are resolved in the
+// merged view. Renaming a directory that exists in lower fails with EXDEV, as
+// on overlayfs without the redirect_dir feature.
+func Overlay(lower absfs.FileSystem) (*OverlayFS, error) {
+	if lower == nil {
+		return nil, &os.PathError{Op: "overlay", Path: "/", Err: syscall.EINVAL}
+	}
+	upper, err := NewFS()
+	if err != nil {
+		return nil, err
+	}
+	priv := upper.View()
+	priv.Uid, priv.Gid, priv.Groups = 0, 0, nil
+	priv.Umask = 0777
+	return &OverlayFS{upper: upper, priv: priv, lower: lower, cwd: "/"}, nil
+}
+
+// Upper returns the upper layer of o, which holds the files changed through o,
+// whiteouts and opaque directories. Its credentials and umask are used for
+// files created through o.
+func (o *OverlayFS) Upper() *FileSystem {
+	return o.upper
+}
+
+// Lower returns the lower layer of o.
+func (o *OverlayFS) Lower() absfs.FileSystem {
+	return o.lower
+}
+
+func (o *OverlayFS) Separator() uint8 {
+	return '/'
+}
+
+func (o *OverlayFS) ListSeparator() uint8 {
+	return ':'
+}
+
+func (o *OverlayFS) Chdir(name string) error {
+	p, err := o.resolve(name, true)
+	if err == nil {
+		var info os.FileInfo
+		info, _, err = o.lstat(p)
+		if err == nil && !info.IsDir() {
+			err = syscall.ENOTDIR
+		}
+	}
+	if err != nil {
+		return &os.PathError{Op: "chdir", Path: name, Err: err}
+	}
+	o.cwd = p
+	return nil
+}
+
+func (o *OverlayFS) Getwd() (string, error) {
+	return o.cwd, nil
+}
+
+func (o *OverlayFS) TempDir() string {
+	return o.upper.Tempdir
+}
+
+func (o *OverlayFS) Open(name string) (absfs.File, error) {
+	return o.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (o *OverlayFS) Create(name string) (absfs.File, error) {
+	return o.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+}
+
+// OpenFile opens the named file. Files that are only in the lower layer are
+// opened there if flag allows reading only, and copied up otherwise.
+func (o *OverlayFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := o.openFile(name, flag, perm)
+	if err != nil {
+		return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+func (o *OverlayFS) openFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	access := flag & absfs.O_ACCESS
+	create := flag&os.O_CREATE != 0
+	excl := create && flag&os.O_EXCL != 0
+	write := access != os.O_RDONLY || flag&os.O_TRUNC != 0
+
+	p, err := o.resolve(name, !excl && flag&O_NOFOLLOW == 0)
+	if err != nil {
+		return nil, err
+	}
+	info, upper, err := o.lstat(p)
+	switch {
+	case os.IsNotExist(err):
+		if !create {
+			return nil, syscall.ENOENT
+		}
+		if strings.HasSuffix(name, "/") {
+			return nil, syscall.EISDIR
+		}
+		var f absfs.File
+		err = o.create(p, func() (err error) {
+			f, err = o.upper.OpenFile(p, flag, perm)
+			return err
+		})
+		return f, err
+	case err != nil:
+		return nil, err
+	case excl:
+		return nil, syscall.EEXIST
+	case info.Mode()&os.ModeSymlink != 0:
+		return nil, syscall.ELOOP
+	case info.IsDir():
+		if write {
+			return nil, syscall.EISDIR
+		}
+		return &overlayDir{o: o, name: name, path: p}, nil
+	}
+
+	flag &^= os.O_CREATE | os.O_EXCL
+	if write && !upper {
+		err = o.copyUp(p)
+		if err != nil {
+			return nil, err
+		}
+		upper = true
+	}
+	var f absfs.File
+	if upper {
+		f, err = o.upper.OpenFile(p, flag, perm)
+	} else {
+		f, err = o.lower.OpenFile(o.lowerName(p), flag, perm)
+	}
+	if err != nil {
+		return nil, unwrap(err)
+	}
+	return f, nil
+}
+
+func (o *OverlayFS) Mkdir(name string, perm os.FileMode) error {
+	p, err := o.resolve(name, false)
+	if err == nil {
+		_, _, err = o.lstat(p)
+		switch {
+		case err == nil:
+			err = syscall.EEXIST
+		case os.IsNotExist(err):
+			err = o.create(p, func() error {
+				return o.upper.Mkdir(p, perm)
+			})
+		}
+	}
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (o *OverlayFS) MkdirAll(name string, perm os.FileMode) error {
+	path := ""
+	if filepath.IsAbs(name) {
+		path = "/"
+	}
+	for _, p := range strings.Split(name, "/") {
+		if p == "" {
+			continue
+		}
+		path = filepath.Join(path, p)
+		info, err := o.Stat(path)
+		if err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		err = o.Mkdir(path, perm)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OverlayFS) Remove(name string) error {
+	p, err := o.resolve(name, false)
+	if err == nil {
+		err = o.remove(p)
+	}
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// remove removes the file or empty directory p, leaving a whiteout if it
+// exists in the lower layer.
+func (o *OverlayFS) remove(p string) error {
+	if p == "/" {
+		return syscall.EBUSY
+	}
+	info, upper, err := o.lstat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		infos, err := o.readdir(p)
+		if err != nil {
+			return err
+		}
+		if len(infos) > 0 {
+			return syscall.ENOTEMPTY
+		}
+	}
+	err = o.parentDir(p)
+	if err != nil {
+		return err
+	}
+	lower := o.lowerExists(p)
+	if upper {
+		// an empty directory may still hold whiteouts
+		err = unwrap(o.priv.RemoveAll(p))
+		if err != nil {
+			return err
+		}
+	}
+	if lower {
+		return o.whiteout(p)
+	}
+	return nil
+}
+
+func (o *OverlayFS) RemoveAll(name string) error {
+	p, err := o.resolve(name, false)
+	if err == nil {
+		err = o.removeAll(p)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (o *OverlayFS) removeAll(p string) error {
+	info, _, err := o.lstat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		infos, err := o.readdir(p)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			err = o.removeAll(filepath.Join(p, info.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return o.remove(p)
+}
+
+func (o *OverlayFS) Rename(oldpath, newpath string) error {
+	err := o.rename(oldpath, newpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+func (o *OverlayFS) rename(oldpath, newpath string) error {
+	op, err := o.resolve(oldpath, false)
+	if err != nil {
+		return err
+	}
+	np, err := o.resolve(newpath, false)
+	if err != nil {
+		return err
+	}
+	info, _, err := o.lstat(op)
+	if err != nil {
+		return err
+	}
+	switch {
+	case op == "/" || np == "/":
+		return syscall.EBUSY
+	case op == np:
+		return nil
+	case strings.HasPrefix(np, op+"/"):
+		return syscall.EINVAL
+	}
+	lower := o.lowerExists(op)
+	if info.IsDir() && lower {
+		return syscall.EXDEV
+	}
+
+	dst, _, err := o.lstat(np)
+	exists := err == nil
+	switch {
+	case exists:
+		if dst.IsDir() && !info.IsDir() {
+			return syscall.EISDIR
+		}
+		if !dst.IsDir() && info.IsDir() {
+			return syscall.ENOTDIR
+		}
+		if dst.IsDir() {
+			infos, err := o.readdir(np)
+			if err != nil {
+				return err
+			}
+			if len(infos) > 0 {
+				return syscall.ENOTEMPTY
+			}
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	// newpath is only replaced once everything that can fail has been
+	// checked, so that a failed rename leaves it in place
+	err = o.parentDir(op)
+	if err != nil {
+		return err
+	}
+	err = o.copyUp(op)
+	if err != nil {
+		return err
+	}
+	err = o.parentDir(np)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = o.remove(np)
+		if err != nil {
+			return err
+		}
+	}
+	err = o.create(np, func() error {
+		return o.upper.Rename(op, np)
+	})
+	if err != nil {
+		return err
+	}
+	if lower {
+		return o.whiteout(op)
+	}
+	return nil
+}
+
+func (o *OverlayFS) Stat(name string) (os.FileInfo, error) {
+	info, err := o.stat(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (o *OverlayFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := o.stat(name, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (o *OverlayFS) stat(name string, follow bool) (os.FileInfo, error) {
+	p, err := o.resolve(name, follow)
+	if err != nil {
+		return nil, err
+	}
+	info, _, err := o.lstat(p)
+	return info, err
+}
+
+func (o *OverlayFS) Chmod(name string, mode os.FileMode) error {
+	return o.change("chmod", name, true, func(p string) error {
+		return o.upper.Chmod(p, mode)
+	})
+}
+
+func (o *OverlayFS) Chown(name string, uid, gid int) error {
+	return o.change("chown", name, true, func(p string) error {
+		return o.upper.Chown(p, uid, gid)
+	})
+}
+
+func (o *OverlayFS) Lchown(name string, uid, gid int) error {
+	return o.change("lchown", name, false, func(p string) error {
+		return o.upper.Lchown(p, uid, gid)
+	})
+}
+
+func (o *OverlayFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return o.change("chtimes", name, true, func(p string) error {
+		return o.upper.Chtimes(p, atime, mtime)
+	})
+}
+
+func (o *OverlayFS) Truncate(name string, size int64) error {
+	return o.change("truncate", name, true, func(p string) error {
+		return o.upper.Truncate(p, size)
+	})
+}
+
+// change copies up the named file and calls fn with its path to change it in
+// the upper layer.
+func (o *OverlayFS) change(op, name string, follow bool, fn func(p string) error) error {
+	p, err := o.resolve(name, follow)
+	if err == nil {
+		err = o.copyUp(p)
+	}
+	if err == nil {
+		err = unwrap(fn(p))
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+func (o *OverlayFS) Readlink(name string) (string, error) {
+	p, err := o.resolve(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	target, err := o.readlink(p)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+func (o *OverlayFS) Symlink(oldname, newname string) error {
+	p, err := o.resolve(newname, false)
+	if err == nil {
+		_, _, err = o.lstat(p)
+		switch {
+		case err == nil:
+			err = syscall.EEXIST
+		case os.IsNotExist(err):
+			err = o.create(p, func() error {
+				return o.upper.Symlink(oldname, p)
+			})
+		}
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// resolve returns the absolute path of name in the merged view, with the
+// symbolic links in every element but the last replaced by their targets.
+// The last element is followed only if follow is true or name ends in a
+// slash. The last element does not need to exist.
+func (o *OverlayFS) resolve(name string, follow bool) (string, error) {
+	if name == "" {
+		return "", syscall.ENOENT
+	}
+	trailing := strings.HasSuffix(name, "/")
+	follow = follow || trailing
+	if !filepath.IsAbs(name) {
+		name = o.cwd + "/" + name
+	}
+
+	p := "/"
+	elems := splitPath(name)
+	hops := 0
+	for len(elems) > 0 {
+		elem := elems[0]
+		elems = elems[1:]
+		last := len(elems) == 0
+		if elem == ".." {
+			p = filepath.Dir(p)
+			continue
+		}
+
+		next := filepath.Join(p, elem)
+		info, _, err := o.lstat(next)
+		if err != nil {
+			if last && os.IsNotExist(err) {
+				return next, nil
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 && (!last || follow) {
+			hops++
+			if hops > maxSymlinks {
+				return "", syscall.ELOOP
+			}
+			target, err := o.readlink(next)
+			if err != nil {
+				return "", err
+			}
+			if target == "" {
+				return "", syscall.ENOENT
+			}
+			if filepath.IsAbs(target) {
+				p = "/"
+			}
+			elems = append(splitPath(target), elems...)
+			continue
+		}
+		if !info.IsDir() && (!last || trailing) {
+			return "", syscall.ENOTDIR
+		}
+		p = next
+	}
+	return p, nil
+}
+
+// lstat returns the FileInfo of the resolved path p in the merged view, and
+// whether it comes from the upper layer.
+func (o *OverlayFS) lstat(p string) (os.FileInfo, bool, error) {
+	info, err := o.priv.Lstat(p)
+	if err == nil {
+		if isWhiteout(info) {
+			return nil, false, syscall.ENOENT
+		}
+		return info, true, nil
+	}
+	if !o.lowerVisible(p) {
+		return nil, false, syscall.ENOENT
+	}
+	info, err = o.lowerLstat(p)
+	if err != nil {
+		return nil, false, unwrap(err)
+	}
+	return info, false, nil
+}
+
+func (o *OverlayFS) readlink(p string) (string, error) {
+	info, upper, err := o.lstat(p)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", syscall.EINVAL
+	}
+	if upper {
+		target, err := o.priv.Readlink(p)
+		return target, unwrap(err)
+	}
+	sl, ok := o.lower.(absfs.SymLinker)
+	if !ok {
+		return "", syscall.EINVAL
+	}
+	target, err := sl.Readlink(o.lowerName(p))
+	return target, unwrap(err)
+}
+
+// readdir returns the merged entries of the directory p, sorted by name and
+// without "." and "..".
+func (o *OverlayFS) readdir(p string) ([]os.FileInfo, error) {
+	info, upper, err := o.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	var infos []os.FileInfo
+	seen := make(map[string]bool)
+	merge := true
+	if upper {
+		list, err := readdir(o.priv, p)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range list {
+			name := info.Name()
+			if name == "." || name == ".." {
+				continue
+			}
+			seen[name] = true
+			if !isWhiteout(info) {
+				infos = append(infos, info)
+			}
+		}
+		merge = !o.opaque(p)
+	}
+	if lower, ok := o.lowerInfo(p); merge && ok && lower.IsDir() {
+		list, err := readdir(o.lower, o.lowerName(p))
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range list {
+			name := info.Name()
+			if name == "." || name == ".." || seen[name] {
+				continue
+			}
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+// readdir returns all entries of the directory name of fs.
+func readdir(fs absfs.FileSystem, name string) ([]os.FileInfo, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, unwrap(err)
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil && err != io.EOF {
+		return nil, unwrap(err)
+	}
+	return infos, nil
+}
+
+// create makes room for a new entry p, which does not exist in the merged
+// view, and calls fn to create it in the upper layer. An entry created in
+// place of a whiteout is made opaque if it is a directory.
+func (o *OverlayFS) create(p string, fn func() error) error {
+	err := o.parentDir(p)
+	if err != nil {
+		return err
+	}
+	info, err := o.priv.Lstat(p)
+	whiteout := err == nil && isWhiteout(info)
+	if whiteout {
+		err = o.priv.Remove(p)
+		if err != nil {
+			return unwrap(err)
+		}
+	}
+	err = fn()
+	if err != nil {
+		if whiteout {
+			o.whiteout(p)
+		}
+		return unwrap(err)
+	}
+	info, err = o.priv.Lstat(p)
+	if whiteout && err == nil && info.IsDir() {
+		return unwrap(o.priv.Lsetxattr(p, opaqueXattr, []byte("y"), 0))
+	}
+	return nil
+}
+
+// parentDir copies up the directory containing p and checks that the entries
+// of the directory may be changed with the credentials of the upper layer.
+func (o *OverlayFS) parentDir(p string) error {
+	dir := filepath.Dir(p)
+	err := o.copyUp(dir)
+	if err != nil {
+		return err
+	}
+	o.upper.mu.RLock()
+	defer o.upper.mu.RUnlock()
+	node, err := o.upper.resolve(dir, false)
+	switch {
+	case err != nil:
+		return err
+	case o.upper.rdonly.Load():
+		return syscall.EROFS
+	case !o.upper.access(node, absfs.OS_WRITE|absfs.OS_EX):
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// copyUp copies p and the directories containing it from the lower layer into
+// the upper layer, unless they are already there. Directories are copied
+// without their contents.
+func (o *OverlayFS) copyUp(p string) error {
+	info, upper, err := o.lstat(p)
+	if err != nil || upper {
+		return err
+	}
+	err = o.copyUp(filepath.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	mode := info.Mode()
+	perm := mode & chmodBits
+	switch {
+	case mode.IsDir():
+		err = o.priv.Mkdir(p, perm)
+	case mode&os.ModeSymlink != 0:
+		var target string
+		target, err = o.readlink(p)
+		if err == nil {
+			err = o.priv.Symlink(target, p)
+		}
+	case mode.IsRegular():
+		err = o.copyFile(p, perm)
+	default:
+		err = syscall.EOPNOTSUPP
+	}
+	if err == nil && mode&os.ModeSymlink == 0 {
+		err = o.priv.Chmod(p, perm)
+	}
+	if err != nil {
+		return unwrap(err)
+	}
+
+	uid, gid := o.upper.Uid, o.upper.Gid
+	atime := info.ModTime()
+	if stat, ok := StatOf(info); ok {
+		uid, gid = int(stat.Uid), int(stat.Gid)
+		atime = stat.Atime
+	}
+	err = o.priv.Lchown(p, uid, gid)
+	if err == nil {
+		err = o.priv.Lchtimes(p, atime, info.ModTime())
+	}
+	return unwrap(err)
+}
+
+func (o *OverlayFS) copyFile(p string, perm os.FileMode) error {
+	src, err := o.lower.Open(o.lowerName(p))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := o.priv.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	cerr := dst.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// whiteout creates a whiteout for p in the upper layer, where p must not
+// exist.
+func (o *OverlayFS) whiteout(p string) error {
+	o.upper.mu.Lock()
+	defer o.upper.mu.Unlock()
+	loc, err := o.priv.walk(p, false)
+	switch {
+	case err != nil:
+		return err
+	case loc.mount != nil:
+		return syscall.EXDEV
+	case loc.node != nil:
+		return syscall.EEXIST
+	case o.upper.readOnlyAt(loc):
+		return syscall.EROFS
+	}
+	node := o.upper.newInode(os.ModeDevice | os.ModeCharDevice)
+	return o.upper.link(loc.parent, loc.name, node)
+}
+
+// isWhiteout reports whether info, from the upper layer, describes a whiteout.
+func isWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := StatOf(info)
+	return ok && stat.Rdev == 0
+}
+
+// opaque reports whether the directory p of the upper layer is opaque.
+func (o *OverlayFS) opaque(p string) bool {
+	var value [1]byte
+	n, err := o.priv.Lgetxattr(p, opaqueXattr, value[:])
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// lowerVisible reports whether the lower layer shows through at p, that is
+// whether no directory containing p is replaced or made opaque in the upper
+// layer.
+func (o *OverlayFS) lowerVisible(p string) bool {
+	dir := ""
+	for _, elem := range splitPath(filepath.Dir(p)) {
+		dir += "/" + elem
+		info, err := o.priv.Lstat(dir)
+		if err != nil {
+			return true
+		}
+		if !info.IsDir() || o.opaque(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// lowerInfo returns the FileInfo of p in the lower layer, and false if p does
+// not exist there or is hidden by the upper layer.
+func (o *OverlayFS) lowerInfo(p string) (os.FileInfo, bool) {
+	if !o.lowerVisible(p) {
+		return nil, false
+	}
+	info, err := o.lowerLstat(p)
+	return info, err == nil
+}
+
+// lowerExists reports whether removing p from the merged view requires a
+// whiteout.
+func (o *OverlayFS) lowerExists(p string) bool {
+	_, ok := o.lowerInfo(p)
+	return ok
+}
+
+// lowerName returns the name of the resolved path p in the lower layer.
+func (o *OverlayFS) lowerName(p string) string {
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
+
+// lowerLstat is Lstat in the lower layer, or Stat if it does not support
+// symbolic links.
+func (o *OverlayFS) lowerLstat(p string) (os.FileInfo, error) {
+	if sl, ok := o.lower.(absfs.SymLinker); ok {
+		return sl.Lstat(o.lowerName(p))
+	}
+	return o.lower.Stat(o.lowerName(p))
+}
+
+// ChangeKind is the kind of a Change.
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeModified
+	ChangeDeleted
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Change is a difference between an OverlayFS and its lower layer.
+type Change struct {
+	Path string // absolute path in the overlay.
+	Kind ChangeKind
+}
+
+// Changes returns the files added, modified and deleted through o, sorted by
+// path. The contents of added directories are listed as added, and the entries
+// of a deleted directory only as the directory. A file is modified if its
+// type, mode, size, modification time, contents, link target or, for memfs
+// lower layers, owner differ from the lower layer; a directory only if its
+// type or mode differ or it replaced a deleted directory.
+func (o *OverlayFS) Changes() ([]Change, error) {
+	var changes []Change
+	err := o.changes("/", &changes)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+func (o *OverlayFS) changes(dir string, changes *[]Change) error {
+	list, err := readdir(o.priv, dir)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, info := range list {
+		name := info.Name()
+		if name == "." || name == ".." {
+			continue
+		}
+		present[name] = true
+		p := filepath.Join(dir, name)
+		if isWhiteout(info) {
+			*changes = append(*changes, Change{Path: p, Kind: ChangeDeleted})
+			continue
+		}
+
+		lower, ok := o.lowerInfo(p)
+		if !ok {
+			*changes = append(*changes, Change{Path: p, Kind: ChangeAdded})
+		} else {
+			modified, err := o.modified(p, info, lower)
+			if err != nil {
+				return err
+			}
+			if modified {
+				*changes = append(*changes, Change{Path: p, Kind: ChangeModified})
+			}
+		}
+		if info.IsDir() {
+			err = o.changes(p, changes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// the entries of the lower directory hidden by an opaque directory
+	if !o.opaque(dir) {
+		return nil
+	}
+	if lower, ok := o.lowerInfo(dir); !ok || !lower.IsDir() {
+		return nil
+	}
+	list, err = readdir(o.lower, o.lowerName(dir))
+	if err != nil {
+		return err
+	}
+	for _, info := range list {
+		name := info.Name()
+		if name == "." || name == ".." || present[name] {
+			continue
+		}
+		*changes = append(*changes, Change{Path: filepath.Join(dir, name), Kind: ChangeDeleted})
+	}
+	return nil
+}
+
+// modified reports whether p, described by info in the upper layer and lower
+// in the lower layer, was changed.
+func (o *OverlayFS) modified(p string, info, lower os.FileInfo) (bool, error) {
+	if info.Mode() != lower.Mode() {
+		return true, nil
+	}
+	if info.IsDir() {
+		return o.opaque(p), nil
+	}
+	if info.Size() != lower.Size() || !info.ModTime().Equal(lower.ModTime()) {
+		return true, nil
+	}
+	if stat, ok := StatOf(lower); ok {
+		upper, _ := StatOf(info)
+		if upper.Uid != stat.Uid || upper.Gid != stat.Gid {
+			return true, nil
+		}
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := o.priv.Readlink(p)
+		if err != nil {
+			return false, err
+		}
+		sl, ok := o.lower.(absfs.SymLinker)
+		if !ok {
+			return true, nil
+		}
+		old, err := sl.Readlink(o.lowerName(p))
+		if err != nil {
+			return false, err
+		}
+		return target != old, nil
+	}
+	data, err := readAll(o.priv, p)
+	if err != nil {
+		return false, err
+	}
+	old, err := readAll(o.lower, o.lowerName(p))
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(data, old), nil
+}
+
+// readAll returns the contents of the file name of fs.
+func readAll(fs absfs.FileSystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// overlayDir is an open directory of an OverlayFS, listing the merged entries.
+type overlayDir struct {
+	o    *OverlayFS
+	name string // name the directory was opened with.
+	path string // resolved path of the directory.
+
+	infos []os.FileInfo // entries not returned yet.
+	read  bool          // whether infos was filled.
+}
+
+func (d *overlayDir) Name() string {
+	return d.name
+}
+
+func (d *overlayDir) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *overlayDir) ReadAt(b []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *overlayDir) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EBADF}
+}
+
+func (d *overlayDir) WriteAt(b []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EBADF}
+}
+
+func (d *overlayDir) WriteString(s string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EBADF}
+}
+
+func (d *overlayDir) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: d.name, Err: syscall.EBADF}
+}
+
+func (d *overlayDir) Close() error {
+	return nil
+}
+
+func (d *overlayDir) Sync() error {
+	return nil
+}
+
+// Seek rewinds the directory listing. Only an offset of 0 from the start is
+// supported.
+func (d *overlayDir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, &os.PathError{Op: "seek", Path: d.name, Err: syscall.EINVAL}
+	}
+	d.infos, d.read = nil, false
+	return 0, nil
+}
+
+func (d *overlayDir) Stat() (os.FileInfo, error) {
+	info, _, err := d.o.lstat(d.path)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: d.name, Err: err}
+	}
+	return info, nil
+}
+
+// Readdir returns the next n entries of the directory, or all remaining
+// entries if n <= 0, like os.File.Readdir.
+func (d *overlayDir) Readdir(n int) ([]os.FileInfo, error) {
+	if !d.read {
+		infos, err := d.o.readdir(d.path)
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.infos, d.read = infos, true
+	}
+	if n <= 0 {
+		infos := d.infos
+		d.infos = nil
+		return infos, nil
+	}
+	if len(d.infos) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.infos) {
+		n = len(d.infos)
+	}
+	infos := d.infos[:n]
+	d.infos = d.infos[n:]
+	return infos, nil
+}
+
+func (d *overlayDir) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
@@ -0,0 +1,352 @@
+package memfs_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+	"github.com/absfs/osfs"
+)
+
+func readdirnames(t *testing.T, fs absfs.FileSystem, name string) []string {
+	t.Helper()
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestOverlay(t *testing.T) {
+	lower, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lower.MkdirAll("/src/pkg", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lower.MkdirAll("/gone/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, lower, "/src/main.go", "package main")
+	writeFile(t, lower, "/src/pkg/lib.go", "package pkg")
+	writeFile(t, lower, "/src/README", "readme")
+	writeFile(t, lower, "/gone/sub/file", "file")
+	writeFile(t, lower, "/same", "same")
+	err = lower.Symlink("src/pkg", "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower.SetReadOnly(true)
+
+	fs, err := memfs.Overlay(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ absfs.SymlinkFileSystem = fs
+
+	// Reads fall through to the lower layer.
+	if s := readFile(t, fs, "/src/main.go"); s != "package main" {
+		t.Errorf("wrong content %q", s)
+	}
+	if s := readFile(t, fs, "/link/lib.go"); s != "package pkg" {
+		t.Errorf("wrong content through link %q", s)
+	}
+
+	// Writes copy up and leave the lower layer unchanged.
+	writeFile(t, fs, "/src/main.go", "package changed")
+	writeFile(t, fs, "/link/new.go", "package new")
+	if s := readFile(t, fs, "/src/main.go"); s != "package changed" {
+		t.Errorf("wrong content after write %q", s)
+	}
+	if s := readFile(t, lower, "/src/main.go"); s != "package main" {
+		t.Errorf("lower layer changed %q", s)
+	}
+	if s := readFile(t, fs.Upper(), "/src/pkg/new.go"); s != "package new" {
+		t.Errorf("wrong content in upper layer %q", s)
+	}
+	f, err := fs.OpenFile("/same", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Readdir merges both layers.
+	names := readdirnames(t, fs, "/src")
+	if !equalStrings(names, []string{"README", "main.go", "pkg"}) {
+		t.Errorf("wrong entries %q", names)
+	}
+	names = readdirnames(t, fs, "/src/pkg")
+	if !equalStrings(names, []string{"lib.go", "new.go"}) {
+		t.Errorf("wrong entries %q", names)
+	}
+
+	// Removing a lower file leaves a whiteout.
+	err = fs.Remove("/src/README")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/src/README"); !os.IsNotExist(err) {
+		t.Errorf("removed file visible: %v", err)
+	}
+	info, err := fs.Upper().Lstat("/src/README")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		t.Errorf("whiteout has mode %s", info.Mode())
+	}
+	names = readdirnames(t, fs, "/src")
+	if !equalStrings(names, []string{"main.go", "pkg"}) {
+		t.Errorf("wrong entries after remove %q", names)
+	}
+	if _, err := lower.Stat("/src/README"); err != nil {
+		t.Errorf("lower file removed: %v", err)
+	}
+
+	// A directory created in place of a removed one is opaque.
+	err = fs.Remove("/gone")
+	if !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("expected ENOTEMPTY, got %v", err)
+	}
+	err = fs.RemoveAll("/gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/gone", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = readdirnames(t, fs, "/gone")
+	if len(names) != 0 {
+		t.Errorf("opaque directory shows lower entries %q", names)
+	}
+	if _, err := fs.Stat("/gone/sub/file"); !os.IsNotExist(err) {
+		t.Errorf("hidden file visible: %v", err)
+	}
+	writeFile(t, fs, "/gone/fresh", "fresh")
+
+	// Renames.
+	err = fs.Rename("/src", "/moved")
+	if !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("expected EXDEV renaming a lower directory, got %v", err)
+	}
+	err = fs.Rename("/src/pkg/lib.go", "/lib.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/lib.go"); s != "package pkg" {
+		t.Errorf("wrong content after rename %q", s)
+	}
+	if _, err := fs.Stat("/src/pkg/lib.go"); !os.IsNotExist(err) {
+		t.Errorf("renamed file still visible: %v", err)
+	}
+	err = fs.Mkdir("/new", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Rename("/new", "/newer")
+	if err != nil {
+		t.Errorf("renaming an upper directory: %v", err)
+	}
+	err = fs.Chmod("/link", 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := fs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []memfs.Change{
+		{Path: "/gone", Kind: memfs.ChangeModified},
+		{Path: "/gone/fresh", Kind: memfs.ChangeAdded},
+		{Path: "/gone/sub", Kind: memfs.ChangeDeleted},
+		{Path: "/lib.go", Kind: memfs.ChangeAdded},
+		{Path: "/newer", Kind: memfs.ChangeAdded},
+		{Path: "/src/README", Kind: memfs.ChangeDeleted},
+		{Path: "/src/main.go", Kind: memfs.ChangeModified},
+		{Path: "/src/pkg", Kind: memfs.ChangeModified},
+		{Path: "/src/pkg/lib.go", Kind: memfs.ChangeDeleted},
+		{Path: "/src/pkg/new.go", Kind: memfs.ChangeAdded},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("wrong changes %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d: got %v, want %v", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestOverlayErrors(t *testing.T) {
+	lower, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lower.Mkdir("/dir", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, lower, "/dir/file", "file")
+	writeFile(t, lower, "/dir/other", "other")
+	fs, err := memfs.Overlay(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.OpenFile("/dir/file", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("expected EEXIST, got %v", err)
+	}
+	_, err = fs.OpenFile("/dir", os.O_WRONLY, 0)
+	if !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("expected EISDIR, got %v", err)
+	}
+	_, err = fs.Stat("/dir/file/x")
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+	err = fs.Mkdir("/dir", 0755)
+	if !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("expected EEXIST, got %v", err)
+	}
+	err = fs.Rename("/dir/file", "/dir")
+	if !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("expected EISDIR, got %v", err)
+	}
+
+	// A failed create does not lose the whiteout.
+	err = fs.Remove("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Chmod("/dir", 0500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/open", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chmod("/open", 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/open/victim", "victim")
+	fs.Upper().Uid = 1000
+	_, err = fs.Create("/dir/file")
+	if !errors.Is(err, syscall.EACCES) {
+		t.Errorf("expected EACCES, got %v", err)
+	}
+	if _, err := fs.Stat("/dir/file"); !os.IsNotExist(err) {
+		t.Errorf("removed file visible again: %v", err)
+	}
+
+	// A failed rename does not remove the destination.
+	err = fs.Rename("/dir/other", "/open/victim")
+	if !errors.Is(err, syscall.EACCES) {
+		t.Errorf("expected EACCES, got %v", err)
+	}
+	if s := readFile(t, fs, "/open/victim"); s != "victim" {
+		t.Errorf("wrong destination content after a failed rename %q", s)
+	}
+
+	err = fs.Chdir("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, _ := fs.Getwd(); dir != "/dir" {
+		t.Errorf("wrong working directory %q", dir)
+	}
+	if _, err := fs.Lstat("."); err != nil {
+		t.Errorf("lstat of the working directory: %v", err)
+	}
+}
+
+func TestOverlayOS(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "src"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "src", "main.c"), []byte("int main;"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "Makefile"), []byte("all:"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ofs, err := osfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ofs.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := memfs.Overlay(ofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/src/main.c"); s != "int main;" {
+		t.Errorf("wrong content %q", s)
+	}
+	err = fs.Mkdir("/build", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/build/main.o", "object")
+	err = fs.Chtimes("/Makefile", time.Unix(0, 0), time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Remove("/src/main.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := fs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []memfs.Change{
+		{Path: "/Makefile", Kind: memfs.ChangeModified},
+		{Path: "/build", Kind: memfs.ChangeAdded},
+		{Path: "/build/main.o", Kind: memfs.ChangeAdded},
+		{Path: "/src/main.c", Kind: memfs.ChangeDeleted},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("wrong changes %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d: got %v, want %v", i, changes[i], want[i])
+		}
+	}
+
+	// The directory on disk is untouched.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("wrong number of entries on disk %d", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src", "main.c")); err != nil {
+		t.Errorf("file removed from disk: %v", err)
+	}
+}
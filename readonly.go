@@ -0,0 +1,25 @@
+package memfs
+
+// SetReadOnly makes the files of fs, which are shared with the FileSystems
+// derived from it by View and Sub, read-only or writable again. While
+// read-only, every operation that would modify a file or directory fails with
+// EROFS, including writes to files opened before, and reads do not update
+// access times. Filesystems mounted inside fs are not affected; use the
+// ReadOnly mount option for those.
+func (fs *FileSystem) SetReadOnly(readOnly bool) {
+	fs.rdonly.Store(readOnly)
+}
+
+// ReadOnly reports whether fs is read-only. See SetReadOnly.
+func (fs *FileSystem) ReadOnly() bool {
+	return fs.rdonly.Load()
+}
+
+// readOnlyAt reports whether the file at loc may not be modified, either
+// because fs is read-only or because loc is in a read-only mount.
+func (fs *FileSystem) readOnlyAt(loc location) bool {
+	if loc.mount != nil {
+		return loc.mount.opts.ReadOnly
+	}
+	return fs.rdonly.Load()
+}
@@ -0,0 +1,174 @@
+package memfs_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestReadOnly(t *testing.T) {
+	clock := memfs.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	fs, err := memfs.NewFSWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.AtimePolicy = memfs.StrictAtime
+	err = fs.MkdirAll("/dir/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/dir/file", "data")
+	err = fs.Symlink("file", "/dir/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := fs.OpenFile("/dir/file", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+
+	fs.SetReadOnly(true)
+	view := fs.View()
+	if !view.ReadOnly() {
+		t.Fatal("view of a read-only FileSystem is writable")
+	}
+	clock.Advance(time.Hour)
+	before, _ := fs.Stat("/dir/file")
+
+	openFile := func(name string, flag int) error {
+		f, err := view.OpenFile(name, flag, 0644)
+		if err == nil {
+			f.Close()
+		}
+		return err
+	}
+	write := func() error {
+		_, err := open.Write([]byte("x"))
+		return err
+	}
+	ops := map[string]error{
+		"open write":    openFile("/dir/file", os.O_WRONLY),
+		"open rdwr":     openFile("/dir/file", os.O_RDWR),
+		"open trunc":    openFile("/dir/file", os.O_RDONLY|os.O_TRUNC),
+		"create":        openFile("/dir/new", os.O_CREATE|os.O_RDONLY),
+		"mkdir":         view.Mkdir("/dir/new", 0755),
+		"mkdirall":      view.MkdirAll("/dir/a/b", 0755),
+		"remove":        view.Remove("/dir/file"),
+		"remove dir":    view.Remove("/dir/sub"),
+		"removeall":     view.RemoveAll("/dir"),
+		"rename":        view.Rename("/dir/file", "/dir/moved"),
+		"chmod":         view.Chmod("/dir/file", 0600),
+		"chown":         view.Chown("/dir/file", 1, 1),
+		"lchown":        view.Lchown("/dir/link", 1, 1),
+		"chtimes":       view.Chtimes("/dir/file", time.Now(), time.Now()),
+		"lchtimes":      view.Lchtimes("/dir/link", time.Now(), time.Now()),
+		"symlink":       view.Symlink("file", "/dir/newlink"),
+		"truncate":      view.Truncate("/dir/file", 0),
+		"setxattr":      view.Setxattr("/dir/file", "user.a", nil, 0),
+		"setacl":        view.SetACL("/dir/file", memfs.ACL{{Tag: memfs.ACL_USER_OBJ, Perm: 6}, {Tag: memfs.ACL_GROUP_OBJ, Perm: 4}, {Tag: memfs.ACL_OTHER, Perm: 4}}),
+		"write":         write(),
+		"file truncate": open.Truncate(0),
+	}
+	for op, err := range ops {
+		if !errors.Is(err, syscall.EROFS) {
+			t.Errorf("%s: expected EROFS, got %v", op, err)
+		}
+	}
+
+	// Reads work and do not update access times.
+	if s := readFile(t, view, "/dir/link"); s != "data" {
+		t.Errorf("wrong content %q", s)
+	}
+	err = openFile("/dir/file", os.O_CREATE|os.O_RDONLY)
+	if err != nil {
+		t.Errorf("open existing file with O_CREATE: %v", err)
+	}
+	after, _ := fs.Stat("/dir/file")
+	if !after.(interface{ ModTime() time.Time }).ModTime().Equal(before.ModTime()) {
+		t.Errorf("modification time changed")
+	}
+	if a, b := atime(t, before), atime(t, after); !a.Equal(b) {
+		t.Errorf("access time changed from %s to %s", a, b)
+	}
+
+	fs.SetReadOnly(false)
+	if err := view.Remove("/dir/file"); err != nil {
+		t.Errorf("remove after SetReadOnly(false): %v", err)
+	}
+}
+
+func atime(t *testing.T, info os.FileInfo) time.Time {
+	t.Helper()
+	stat, ok := memfs.StatOf(info)
+	if !ok {
+		t.Fatal("not a memfs FileInfo")
+	}
+	return stat.Atime
+}
+
+func TestReadOnlyMount(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/ro", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, inner, "/file", "fixture")
+	err = fs.Mount("/ro", inner, memfs.MountOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, fs, "/ro/file"); s != "fixture" {
+		t.Errorf("wrong content %q", s)
+	}
+	f, err := fs.OpenFile("/ro/file", os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		t.Errorf("open existing file with O_CREATE: %v", err)
+	} else {
+		f.Close()
+	}
+
+	_, err = fs.OpenFile("/ro/file", os.O_WRONLY, 0)
+	ops := map[string]error{
+		"open write": err,
+		"create":     writeErr(fs, "/ro/new"),
+		"mkdir":      fs.Mkdir("/ro/dir", 0755),
+		"remove":     fs.Remove("/ro/file"),
+		"rename":     fs.Rename("/ro/file", "/ro/moved"),
+		"chmod":      fs.Chmod("/ro/file", 0600),
+		"symlink":    fs.Symlink("file", "/ro/link"),
+		"truncate":   fs.Truncate("/ro/file", 0),
+	}
+	for op, err := range ops {
+		if !errors.Is(err, syscall.EROFS) {
+			t.Errorf("%s: expected EROFS, got %v", op, err)
+		}
+	}
+
+	// The outer filesystem and the mounted one itself stay writable.
+	writeFile(t, fs, "/outer", "outer")
+	writeFile(t, inner, "/direct", "direct")
+	if s := readFile(t, fs, "/ro/direct"); s != "direct" {
+		t.Errorf("wrong content %q", s)
+	}
+}
+
+func writeErr(fs *memfs.FileSystem, name string) error {
+	f, err := fs.Create(name)
+	if err == nil {
+		f.Close()
+	}
+	return err
+}
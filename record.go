@@ -0,0 +1,643 @@
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// Trace is a sequence of recorded calls, in the order they returned. A Trace
+// can be serialized with encoding/json.
+type Trace []Op
+
+// Op is a recorded call of a method of absfs.SymlinkFileSystem or absfs.File,
+// with its arguments, results and duration. The methods of files are named
+// with the prefix "File.", as in "File.Read". Fields not used by a method are
+// left empty.
+type Op struct {
+	Op      string      `json:"op"`
+	File    int         `json:"file,omitempty"` // handle of the file called or opened, from 1.
+	Name    string      `json:"name,omitempty"`
+	NewName string      `json:"newname,omitempty"` // of Rename and Symlink.
+	Target  string      `json:"target,omitempty"`  // of Symlink.
+	Flag    int         `json:"flag,omitempty"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	Uid     int         `json:"uid,omitempty"`
+	Gid     int         `json:"gid,omitempty"`
+	Atime   *time.Time  `json:"atime,omitempty"`
+	Mtime   *time.Time  `json:"mtime,omitempty"`
+	Off     int64       `json:"off,omitempty"` // offset, or size of Truncate.
+	Whence  int         `json:"whence,omitempty"`
+	N       int         `json:"n,omitempty"`    // buffer length of reads, count of Readdir.
+	Data    []byte      `json:"data,omitempty"` // data written.
+
+	Result   Result        `json:"result"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Result is the outcome of a recorded call. Errors are recorded by their
+// errno, such as "no such file or directory", without the paths that differ
+// between file systems.
+type Result struct {
+	Err   string   `json:"err,omitempty"`
+	N     int64    `json:"n,omitempty"`    // bytes read or written, or offset of Seek.
+	Data  []byte   `json:"data,omitempty"` // data read.
+	Str   string   `json:"str,omitempty"`  // of Getwd, TempDir and Readlink.
+	Info  *Info    `json:"info,omitempty"` // of Stat and Lstat.
+	Infos []Info   `json:"infos,omitempty"`
+	Names []string `json:"names,omitempty"`
+}
+
+// Info is the part of an os.FileInfo that is comparable between file systems.
+// The size of directories is recorded as 0, and the name only for directory
+// entries. The entries of Readdir and Readdirnames are sorted, without "."
+// and "..".
+type Info struct {
+	Name string      `json:"name,omitempty"`
+	Mode os.FileMode `json:"mode"`
+	Size int64       `json:"size,omitempty"`
+}
+
+// String formats op as a call, for example Mkdir("/a", drwxr-xr-x).
+func (op Op) String() string {
+	var args []string
+	if op.File != 0 && strings.HasPrefix(op.Op, "File.") {
+		args = append(args, fmt.Sprintf("#%d", op.File))
+	}
+	if op.Target != "" {
+		args = append(args, fmt.Sprintf("%q", op.Target))
+	}
+	if op.Name != "" {
+		args = append(args, fmt.Sprintf("%q", op.Name))
+	}
+	if op.NewName != "" {
+		args = append(args, fmt.Sprintf("%q", op.NewName))
+	}
+	switch op.Op {
+	case "OpenFile":
+		args = append(args, fmt.Sprintf("%#x", op.Flag), op.Mode.String())
+	case "Mkdir", "MkdirAll", "Chmod":
+		args = append(args, op.Mode.String())
+	case "Chown", "Lchown":
+		args = append(args, fmt.Sprint(op.Uid), fmt.Sprint(op.Gid))
+	case "Chtimes":
+		args = append(args, op.Atime.String(), op.Mtime.String())
+	case "Truncate", "File.Truncate", "File.ReadAt", "File.WriteAt":
+		args = append(args, fmt.Sprint(op.Off))
+	case "File.Seek":
+		args = append(args, fmt.Sprint(op.Off), fmt.Sprint(op.Whence))
+	}
+	switch op.Op {
+	case "File.Read", "File.ReadAt", "File.Readdir", "File.Readdirnames":
+		args = append(args, fmt.Sprint(op.N))
+	case "File.Write", "File.WriteAt", "File.WriteString":
+		args = append(args, fmt.Sprintf("%q", op.Data))
+	}
+	return op.Op + "(" + strings.Join(args, ", ") + ")"
+}
+
+// String formats the fields of r that are set.
+func (r Result) String() string {
+	var s []string
+	if r.N != 0 {
+		s = append(s, fmt.Sprintf("n=%d", r.N))
+	}
+	if r.Data != nil {
+		s = append(s, fmt.Sprintf("data=%q", r.Data))
+	}
+	if r.Str != "" {
+		s = append(s, fmt.Sprintf("%q", r.Str))
+	}
+	if r.Info != nil {
+		s = append(s, fmt.Sprintf("%s size=%d", r.Info.Mode, r.Info.Size))
+	}
+	if r.Infos != nil {
+		s = append(s, fmt.Sprint(r.Infos))
+	}
+	if r.Names != nil {
+		s = append(s, fmt.Sprint(r.Names))
+	}
+	if r.Err != "" {
+		s = append(s, "error "+r.Err)
+	}
+	if len(s) == 0 {
+		return "ok"
+	}
+	return strings.Join(s, " ")
+}
+
+// Recorder is an absfs.SymlinkFileSystem recording the calls made to it and
+// to the files it opens before passing them to another file system.
+type Recorder struct {
+	fs absfs.SymlinkFileSystem
+
+	mu    sync.Mutex
+	trace Trace
+	files int // last file handle.
+}
+
+// Record returns a Recorder recording the calls made to fs.
+func Record(fs absfs.SymlinkFileSystem) *Recorder {
+	return &Recorder{fs: fs}
+}
+
+// Trace returns a copy of the calls recorded so far.
+func (r *Recorder) Trace() Trace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(Trace(nil), r.trace...)
+}
+
+// call calls op on the file f, or on the file system if f is nil, and records
+// it.
+func (r *Recorder) call(f absfs.File, op Op, buf []byte) (interface{}, error) {
+	start := time.Now()
+	value, err := apply(r.fs, f, &op, buf)
+	op.Duration = time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if file, ok := value.(absfs.File); ok {
+		r.files++
+		op.File = r.files
+		value = &recordedFile{r: r, id: r.files, f: file}
+	}
+	r.trace = append(r.trace, op)
+	return value, err
+}
+
+func (r *Recorder) Separator() uint8 {
+	return r.fs.Separator()
+}
+
+func (r *Recorder) ListSeparator() uint8 {
+	return r.fs.ListSeparator()
+}
+
+func (r *Recorder) Chdir(name string) error {
+	_, err := r.call(nil, Op{Op: "Chdir", Name: name}, nil)
+	return err
+}
+
+func (r *Recorder) Getwd() (string, error) {
+	s, err := r.call(nil, Op{Op: "Getwd"}, nil)
+	return s.(string), err
+}
+
+func (r *Recorder) TempDir() string {
+	s, _ := r.call(nil, Op{Op: "TempDir"}, nil)
+	return s.(string)
+}
+
+func (r *Recorder) Open(name string) (absfs.File, error) {
+	return r.open(Op{Op: "Open", Name: name})
+}
+
+func (r *Recorder) Create(name string) (absfs.File, error) {
+	return r.open(Op{Op: "Create", Name: name})
+}
+
+func (r *Recorder) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return r.open(Op{Op: "OpenFile", Name: name, Flag: flag, Mode: perm})
+}
+
+func (r *Recorder) open(op Op) (absfs.File, error) {
+	f, err := r.call(nil, op, nil)
+	if err != nil {
+		return &absfs.InvalidFile{Path: op.Name}, err
+	}
+	return f.(absfs.File), nil
+}
+
+func (r *Recorder) Mkdir(name string, perm os.FileMode) error {
+	_, err := r.call(nil, Op{Op: "Mkdir", Name: name, Mode: perm}, nil)
+	return err
+}
+
+func (r *Recorder) MkdirAll(name string, perm os.FileMode) error {
+	_, err := r.call(nil, Op{Op: "MkdirAll", Name: name, Mode: perm}, nil)
+	return err
+}
+
+func (r *Recorder) Remove(name string) error {
+	_, err := r.call(nil, Op{Op: "Remove", Name: name}, nil)
+	return err
+}
+
+func (r *Recorder) RemoveAll(name string) error {
+	_, err := r.call(nil, Op{Op: "RemoveAll", Name: name}, nil)
+	return err
+}
+
+func (r *Recorder) Rename(oldpath, newpath string) error {
+	_, err := r.call(nil, Op{Op: "Rename", Name: oldpath, NewName: newpath}, nil)
+	return err
+}
+
+func (r *Recorder) Stat(name string) (os.FileInfo, error) {
+	info, err := r.call(nil, Op{Op: "Stat", Name: name}, nil)
+	return fileInfo(info), err
+}
+
+func (r *Recorder) Lstat(name string) (os.FileInfo, error) {
+	info, err := r.call(nil, Op{Op: "Lstat", Name: name}, nil)
+	return fileInfo(info), err
+}
+
+func (r *Recorder) Chmod(name string, mode os.FileMode) error {
+	_, err := r.call(nil, Op{Op: "Chmod", Name: name, Mode: mode}, nil)
+	return err
+}
+
+func (r *Recorder) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	_, err := r.call(nil, Op{Op: "Chtimes", Name: name, Atime: &atime, Mtime: &mtime}, nil)
+	return err
+}
+
+func (r *Recorder) Chown(name string, uid, gid int) error {
+	_, err := r.call(nil, Op{Op: "Chown", Name: name, Uid: uid, Gid: gid}, nil)
+	return err
+}
+
+func (r *Recorder) Lchown(name string, uid, gid int) error {
+	_, err := r.call(nil, Op{Op: "Lchown", Name: name, Uid: uid, Gid: gid}, nil)
+	return err
+}
+
+func (r *Recorder) Truncate(name string, size int64) error {
+	_, err := r.call(nil, Op{Op: "Truncate", Name: name, Off: size}, nil)
+	return err
+}
+
+func (r *Recorder) Readlink(name string) (string, error) {
+	s, err := r.call(nil, Op{Op: "Readlink", Name: name}, nil)
+	return s.(string), err
+}
+
+func (r *Recorder) Symlink(oldname, newname string) error {
+	_, err := r.call(nil, Op{Op: "Symlink", Target: oldname, NewName: newname}, nil)
+	return err
+}
+
+// fileInfo returns the os.FileInfo result of a call, or nil.
+func fileInfo(value interface{}) os.FileInfo {
+	info, _ := value.(os.FileInfo)
+	return info
+}
+
+// recordedFile is a file opened by a Recorder.
+type recordedFile struct {
+	r  *Recorder
+	id int
+	f  absfs.File
+}
+
+func (f *recordedFile) call(op Op, buf []byte) (interface{}, error) {
+	op.File = f.id
+	return f.r.call(f.f, op, buf)
+}
+
+func (f *recordedFile) Name() string {
+	return f.f.Name()
+}
+
+func (f *recordedFile) Read(b []byte) (int, error) {
+	n, err := f.call(Op{Op: "File.Read", N: len(b)}, b)
+	return n.(int), err
+}
+
+func (f *recordedFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.call(Op{Op: "File.ReadAt", N: len(b), Off: off}, b)
+	return n.(int), err
+}
+
+func (f *recordedFile) Write(b []byte) (int, error) {
+	n, err := f.call(Op{Op: "File.Write", Data: append([]byte{}, b...)}, nil)
+	return n.(int), err
+}
+
+func (f *recordedFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.call(Op{Op: "File.WriteAt", Data: append([]byte{}, b...), Off: off}, nil)
+	return n.(int), err
+}
+
+func (f *recordedFile) WriteString(s string) (int, error) {
+	n, err := f.call(Op{Op: "File.WriteString", Data: []byte(s)}, nil)
+	return n.(int), err
+}
+
+func (f *recordedFile) Seek(offset int64, whence int) (int64, error) {
+	n, err := f.call(Op{Op: "File.Seek", Off: offset, Whence: whence}, nil)
+	return n.(int64), err
+}
+
+func (f *recordedFile) Close() error {
+	_, err := f.call(Op{Op: "File.Close"}, nil)
+	return err
+}
+
+func (f *recordedFile) Sync() error {
+	_, err := f.call(Op{Op: "File.Sync"}, nil)
+	return err
+}
+
+func (f *recordedFile) Stat() (os.FileInfo, error) {
+	info, err := f.call(Op{Op: "File.Stat"}, nil)
+	return fileInfo(info), err
+}
+
+func (f *recordedFile) Readdir(n int) ([]os.FileInfo, error) {
+	infos, err := f.call(Op{Op: "File.Readdir", N: n}, nil)
+	return infos.([]os.FileInfo), err
+}
+
+func (f *recordedFile) Readdirnames(n int) ([]string, error) {
+	names, err := f.call(Op{Op: "File.Readdirnames", N: n}, nil)
+	return names.([]string), err
+}
+
+func (f *recordedFile) Truncate(size int64) error {
+	_, err := f.call(Op{Op: "File.Truncate", Off: size}, nil)
+	return err
+}
+
+// apply calls op on the file f, or on fs if f is nil, and sets its result.
+// Reads are made into buf, or a new buffer of op.N bytes if buf is nil. apply
+// returns the value returned by the call, of the type it returns.
+func apply(fs absfs.SymlinkFileSystem, f absfs.File, op *Op, buf []byte) (value interface{}, err error) {
+	if f == nil && strings.HasPrefix(op.Op, "File.") {
+		return zeroResult(op.Op), &os.PathError{Op: op.Op, Path: fmt.Sprintf("#%d", op.File), Err: syscall.EBADF}
+	}
+	if buf == nil && (op.Op == "File.Read" || op.Op == "File.ReadAt") {
+		buf = make([]byte, op.N)
+	}
+	res := &op.Result
+	defer func() {
+		res.Err = errString(err)
+	}()
+
+	switch op.Op {
+	case "Chdir":
+		return nil, fs.Chdir(op.Name)
+	case "Getwd":
+		res.Str, err = fs.Getwd()
+		return res.Str, err
+	case "TempDir":
+		res.Str = fs.TempDir()
+		return res.Str, nil
+	case "Open":
+		return fileValue(fs.Open(op.Name))
+	case "Create":
+		return fileValue(fs.Create(op.Name))
+	case "OpenFile":
+		return fileValue(fs.OpenFile(op.Name, op.Flag, op.Mode))
+	case "Mkdir":
+		return nil, fs.Mkdir(op.Name, op.Mode)
+	case "MkdirAll":
+		return nil, fs.MkdirAll(op.Name, op.Mode)
+	case "Remove":
+		return nil, fs.Remove(op.Name)
+	case "RemoveAll":
+		return nil, fs.RemoveAll(op.Name)
+	case "Rename":
+		return nil, fs.Rename(op.Name, op.NewName)
+	case "Stat", "Lstat", "File.Stat":
+		var info os.FileInfo
+		switch op.Op {
+		case "Stat":
+			info, err = fs.Stat(op.Name)
+		case "Lstat":
+			info, err = fs.Lstat(op.Name)
+		default:
+			info, err = f.Stat()
+		}
+		if err == nil {
+			i := infoOf(info)
+			i.Name = ""
+			res.Info = &i
+		}
+		return info, err
+	case "Chmod":
+		return nil, fs.Chmod(op.Name, op.Mode)
+	case "Chtimes":
+		var atime, mtime time.Time
+		if op.Atime != nil {
+			atime = *op.Atime
+		}
+		if op.Mtime != nil {
+			mtime = *op.Mtime
+		}
+		return nil, fs.Chtimes(op.Name, atime, mtime)
+	case "Chown":
+		return nil, fs.Chown(op.Name, op.Uid, op.Gid)
+	case "Lchown":
+		return nil, fs.Lchown(op.Name, op.Uid, op.Gid)
+	case "Truncate":
+		return nil, fs.Truncate(op.Name, op.Off)
+	case "Readlink":
+		res.Str, err = fs.Readlink(op.Name)
+		return res.Str, err
+	case "Symlink":
+		return nil, fs.Symlink(op.Target, op.NewName)
+
+	case "File.Read", "File.ReadAt":
+		var n int
+		if op.Op == "File.Read" {
+			n, err = f.Read(buf)
+		} else {
+			n, err = f.ReadAt(buf, op.Off)
+		}
+		res.N, res.Data = int64(n), append([]byte{}, buf[:n]...)
+		return n, err
+	case "File.Write", "File.WriteAt", "File.WriteString":
+		var n int
+		switch op.Op {
+		case "File.Write":
+			n, err = f.Write(op.Data)
+		case "File.WriteAt":
+			n, err = f.WriteAt(op.Data, op.Off)
+		default:
+			n, err = f.WriteString(string(op.Data))
+		}
+		res.N = int64(n)
+		return n, err
+	case "File.Seek":
+		res.N, err = f.Seek(op.Off, op.Whence)
+		return res.N, err
+	case "File.Close":
+		return nil, f.Close()
+	case "File.Sync":
+		return nil, f.Sync()
+	case "File.Readdir":
+		infos, err := f.Readdir(op.N)
+		res.Infos = []Info{}
+		for _, info := range infos {
+			if info.Name() != "." && info.Name() != ".." {
+				res.Infos = append(res.Infos, infoOf(info))
+			}
+		}
+		sort.Slice(res.Infos, func(i, j int) bool {
+			return res.Infos[i].Name < res.Infos[j].Name
+		})
+		return infos, err
+	case "File.Readdirnames":
+		names, err := f.Readdirnames(op.N)
+		res.Names = []string{}
+		for _, name := range names {
+			if name != "." && name != ".." {
+				res.Names = append(res.Names, name)
+			}
+		}
+		sort.Strings(res.Names)
+		return names, err
+	case "File.Truncate":
+		return nil, f.Truncate(op.Off)
+	}
+	return nil, &os.PathError{Op: op.Op, Path: op.Name, Err: syscall.ENOSYS}
+}
+
+// zeroResult returns the zero value returned by the method op.
+func zeroResult(op string) interface{} {
+	switch op {
+	case "File.Read", "File.ReadAt", "File.Write", "File.WriteAt", "File.WriteString":
+		return 0
+	case "File.Seek":
+		return int64(0)
+	case "File.Readdir":
+		return []os.FileInfo(nil)
+	case "File.Readdirnames":
+		return []string(nil)
+	}
+	return nil
+}
+
+// fileValue returns the results of an open, with a nil interface for a nil
+// file.
+func fileValue(f absfs.File, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// infoOf returns the comparable part of info.
+func infoOf(info os.FileInfo) Info {
+	i := Info{Name: info.Name(), Mode: info.Mode(), Size: info.Size()}
+	if info.IsDir() {
+		i.Size = 0
+	}
+	return i
+}
+
+// errString returns the errno of err as text, or its message if it has no
+// errno.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno.Error()
+	}
+	return unwrap(err).Error()
+}
+
+// Divergence is the error returned by Replay at the first call whose result
+// differs from the recorded one.
+type Divergence struct {
+	Step int    // index of the call in the trace.
+	Op   Op     // the recorded call.
+	Got  Result // the result of the replayed call.
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("step %d: %s: got %s, recorded %s", d.Step, d.Op, d.Got, d.Op.Result)
+}
+
+// Replay makes the calls of trace to fs, comparing their results with the
+// recorded ones, and returns a *Divergence at the first that differs. Absolute
+// paths in the trace are resolved below the working directory of fs when
+// Replay is called, and paths returned by Getwd are mapped back, so that a
+// trace recorded against a FileSystem can be replayed in a directory of the
+// operating system. Symbolic link targets are passed unchanged, and the
+// results of TempDir are not compared. Files left open by the trace are
+// closed.
+func Replay(fs absfs.SymlinkFileSystem, trace Trace) error {
+	base, err := fs.Getwd()
+	if err != nil {
+		return err
+	}
+	files := make(map[int]absfs.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for i, recorded := range trace {
+		op := recorded
+		op.Result = Result{}
+		if op.Name != "" && filepath.IsAbs(op.Name) {
+			op.Name = filepath.Join(base, op.Name)
+		}
+		if op.NewName != "" && filepath.IsAbs(op.NewName) {
+			op.NewName = filepath.Join(base, op.NewName)
+		}
+
+		var f absfs.File
+		if strings.HasPrefix(op.Op, "File.") {
+			f = files[op.File]
+		}
+		value, _ := apply(fs, f, &op, nil)
+		if file, ok := value.(absfs.File); ok {
+			files[op.File] = file
+		}
+		if op.Op == "File.Close" {
+			delete(files, op.File)
+		}
+
+		got := op.Result
+		switch op.Op {
+		case "Getwd":
+			if got.Str == base {
+				got.Str = "/"
+			} else if strings.HasPrefix(got.Str, base+"/") {
+				got.Str = strings.TrimPrefix(got.Str, base)
+			}
+		case "TempDir":
+			got.Str = recorded.Result.Str
+		}
+		if !equalResults(got, recorded.Result) {
+			return &Divergence{Step: i, Op: recorded, Got: got}
+		}
+	}
+	return nil
+}
+
+// equalResults reports whether a and b are equal, treating empty and nil
+// slices, as decoded from JSON, as equal.
+func equalResults(a, b Result) bool {
+	norm := func(r *Result) {
+		if len(r.Data) == 0 {
+			r.Data = nil
+		}
+		if len(r.Infos) == 0 {
+			r.Infos = nil
+		}
+		if len(r.Names) == 0 {
+			r.Names = nil
+		}
+	}
+	norm(&a)
+	norm(&b)
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,168 @@
+package memfs_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+	"github.com/absfs/osfs"
+)
+
+// session makes calls covering the methods of a file system and its files.
+// osfs makes symbolic link targets absolute, so links are optional.
+func session(t *testing.T, fs absfs.SymlinkFileSystem, symlinks bool) {
+	if err := fs.MkdirAll("/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello, "))
+	f.WriteString("world\n")
+	f.Seek(0, io.SeekStart)
+	buf := make([]byte, 5)
+	f.Read(buf)
+	f.ReadAt(buf, 7)
+	f.Truncate(5)
+	f.Sync()
+	f.Stat()
+	f.Close()
+
+	if symlinks {
+		fs.Symlink("file", "/dir/link")
+		fs.Readlink("/dir/link")
+		fs.Lstat("/dir/link")
+		fs.Stat("/dir/link")
+	}
+	fs.Chmod("/dir/file", 0600)
+	fs.Rename("/dir/file", "/dir/sub/moved")
+	fs.Chdir("/dir")
+	fs.Getwd()
+	fs.Mkdir("sub", 0755)
+	fs.Remove("/missing")
+
+	d, err := fs.Open("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Readdir(-1)
+	d.Close()
+	d, err = fs.Open("/dir/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Readdirnames(-1)
+	d.Close()
+	fs.RemoveAll("/dir/sub")
+	fs.Truncate("/dir/sub/moved", 0)
+}
+
+func TestRecord(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := memfs.Record(fs)
+	session(t, r, true)
+	trace := r.Trace()
+
+	want := map[int]string{
+		0:  `MkdirAll("/dir/sub", -rwxr-xr-x): ok`,
+		4:  `File.Seek(#1, 0, 0): ok`,
+		5:  `File.Read(#1, 5): n=5 data="hello"`,
+		9:  `File.Stat(#1): -rw-r--r-- size=5`,
+		13: `Lstat("/dir/link"): Lrwxrwxrwx size=4`,
+		19: `Mkdir("sub", -rwxr-xr-x): error file exists`,
+		20: `Remove("/missing"): error no such file or directory`,
+		22: `File.Readdir(#2, -1): [{link Lrwxrwxrwx 4} {sub drwxr-xr-x 0}]`,
+	}
+	for i, s := range want {
+		if got := trace[i].String() + ": " + trace[i].Result.String(); got != s {
+			t.Errorf("%d: got %s, want %s", i, got, s)
+		}
+	}
+	if trace[1].Op != "Create" || trace[1].File != 1 || trace[21].File != 2 {
+		t.Errorf("wrong file handles: %v, %v", trace[1], trace[21])
+	}
+
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded memfs.Trace
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// replaying in a fresh file system reproduces the results
+	fs2, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memfs.Replay(fs2, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// replaying where a file already exists diverges
+	fs3, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs3, "/missing", "")
+	err = memfs.Replay(fs3, trace)
+	var d *memfs.Divergence
+	if !errors.As(err, &d) || d.Step != 20 || d.Got.Err != "" {
+		t.Errorf("expected divergence at step 20, got %v", err)
+	}
+}
+
+func TestRecordOpenError(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := memfs.Record(fs)
+
+	// a failed open returns the same invalid file as the recorded file system
+	want, werr := fs.Open("/missing")
+	got, err := r.Open("/missing")
+	if got == nil || !os.IsNotExist(err) {
+		t.Fatalf("open of a missing file: %v, %v", got, err)
+	}
+	if got.Name() != want.Name() || err.Error() != werr.Error() {
+		t.Errorf("got %q, %v, want %q, %v", got.Name(), err, want.Name(), werr)
+	}
+	if trace := r.Trace(); len(trace) != 1 || trace[0].File != 0 {
+		t.Errorf("wrong trace %v", trace)
+	}
+}
+
+func TestReplayOS(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := memfs.Record(fs)
+	session(t, r, false)
+
+	ofs, err := osfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ofs.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := memfs.Replay(ofs, r.Trace()); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dir")); err != nil {
+		t.Error(err)
+	}
+}
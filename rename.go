@@ -0,0 +1,156 @@
+package memfs
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/inode"
+)
+
+// Flags for RenameWithFlags, as in renameat2(2). The values match Linux.
+const (
+	// RENAME_NOREPLACE fails with EEXIST instead of replacing an existing
+	// newpath.
+	RENAME_NOREPLACE = 0x1
+
+	// RENAME_EXCHANGE atomically exchanges oldpath and newpath, which must
+	// both exist and may be of different types.
+	RENAME_EXCHANGE = 0x2
+)
+
+// RenameWithFlags renames oldpath to newpath like Rename. flags is zero or one
+// of RENAME_NOREPLACE and RENAME_EXCHANGE.
+func (fs *FileSystem) RenameWithFlags(oldpath, newpath string, flags uint) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.rename(fs.dir, fs.relative(oldpath), fs.dir, fs.relative(newpath), flags)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+// rename moves oldpath, relative to olddir, to newpath, relative to newdir,
+// with the semantics of rename(2). Final symbolic links are renamed rather
+// than followed. An existing newpath is replaced if it is of the same type as
+// oldpath and, for directories, empty.
+func (fs *FileSystem) rename(olddir *inode.Inode, oldpath string, newdir *inode.Inode, newpath string, flags uint) error {
+	exchange := flags&RENAME_EXCHANGE != 0
+	if flags&^(RENAME_NOREPLACE|RENAME_EXCHANGE) != 0 || exchange && flags&RENAME_NOREPLACE != 0 {
+		return syscall.EINVAL
+	}
+
+	// as in rename(2), both parents are resolved before a missing oldpath
+	// is reported
+	src, err := fs.walkFrom(olddir, oldpath, false, 0)
+	if err != nil {
+		return err
+	}
+	dst, err := fs.walkFrom(newdir, newpath, false, 0)
+	if err != nil {
+		return err
+	}
+	if src.mount == nil && src.node == nil {
+		return syscall.ENOENT
+	}
+	if fs.readOnlyAt(src) || fs.readOnlyAt(dst) {
+		return syscall.EROFS
+	}
+	if src.mount != nil || dst.mount != nil {
+		return renameMounted(src, dst, flags)
+	}
+	switch {
+	case src.node == fs.root || dst.node == fs.root:
+		return syscall.EBUSY
+	case src.name == "." || src.name == ".." || dst.name == "." || dst.name == "..":
+		return syscall.EBUSY
+	case !fs.access(src.parent, absfs.OS_WRITE|absfs.OS_EX) || !fs.access(dst.parent, absfs.OS_WRITE|absfs.OS_EX):
+		return syscall.EACCES
+	}
+
+	if exchange {
+		if dst.node == nil {
+			return syscall.ENOENT
+		}
+		if src.node == dst.node {
+			return nil
+		}
+		if contains(src.node, dst.parent) || contains(dst.node, src.parent) {
+			return syscall.EINVAL
+		}
+		return fs.exchange(src.parent, src.name, dst.parent, dst.name)
+	}
+
+	if dst.node != nil {
+		if flags&RENAME_NOREPLACE != 0 {
+			return syscall.EEXIST
+		}
+		// renaming a file onto another link to itself does nothing
+		if src.node == dst.node {
+			return nil
+		}
+	}
+	if contains(src.node, dst.parent) {
+		return syscall.EINVAL
+	}
+	// an ancestor of oldpath is never empty
+	if dst.node != nil && contains(dst.node, src.parent) {
+		return syscall.ENOTEMPTY
+	}
+	if dst.node != nil {
+		switch {
+		case src.node.IsDir() && !dst.node.IsDir():
+			return syscall.ENOTDIR
+		case !src.node.IsDir() && dst.node.IsDir():
+			return syscall.EISDIR
+		case dst.node.IsDir() && !emptyDir(dst.node):
+			return syscall.ENOTEMPTY
+		case dst.node.IsDir():
+			err = fs.rmdir(dst.parent, dst.name, dst.node)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return fs.move(src.parent, src.name, dst.parent, dst.name)
+}
+
+// contains reports whether node is dir or one of its descendants. dir is only
+// a candidate if it is a directory.
+func contains(dir, node *inode.Inode) bool {
+	if !dir.IsDir() {
+		return false
+	}
+	for node != nil {
+		if node == dir {
+			return true
+		}
+		parent := entry(node, "..")
+		if parent == node {
+			return false
+		}
+		node = parent
+	}
+	return false
+}
+
+// renameMounted renames within a mounted filesystem. Renames between different
+// filesystems fail with EXDEV, as do renames of mount points with EBUSY.
+func renameMounted(src, dst location, flags uint) error {
+	switch {
+	case src.mount != nil && src.rest == "." || dst.mount != nil && dst.rest == ".":
+		return syscall.EBUSY
+	case src.mount != dst.mount:
+		return syscall.EXDEV
+	case flags == 0:
+		return unwrap(src.mount.fs.Rename(src.rest, dst.rest))
+	}
+	rfs, ok := src.mount.fs.(interface {
+		RenameWithFlags(oldpath, newpath string, flags uint) error
+	})
+	if !ok {
+		return syscall.EINVAL
+	}
+	return unwrap(rfs.RenameWithFlags(src.rest, dst.rest, flags))
+}
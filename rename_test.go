@@ -0,0 +1,186 @@
+package memfs_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+func writeFile(t *testing.T, fs absfs.FileSystem, name, content string) {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.Write([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, fs absfs.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRename(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/a/b/c", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/empty", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/a/file", "a")
+	writeFile(t, fs, "/file", "root")
+
+	tests := []struct {
+		old, new string
+		err      error
+	}{
+		{"/a", "/a/b/x", syscall.EINVAL},
+		{"/a", "/a/b", syscall.EINVAL},
+		{"/empty", "/a", syscall.ENOTEMPTY},
+		{"/file", "/empty", syscall.EISDIR},
+		{"/empty", "/file", syscall.ENOTDIR},
+		{"/", "/x", syscall.EBUSY},
+		{"/missing", "/x", syscall.ENOENT},
+	}
+	for _, test := range tests {
+		err := fs.Rename(test.old, test.new)
+		if !errors.Is(err, test.err) {
+			t.Errorf("rename %s %s: expected %v, got %v", test.old, test.new, test.err, err)
+		}
+		if _, ok := err.(*os.LinkError); !ok {
+			t.Errorf("rename %s %s: expected *os.LinkError, got %T", test.old, test.new, err)
+		}
+	}
+
+	// An empty directory is replaced, and the link counts stay right.
+	err = fs.Rename("/a/b", "/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/empty/c"); err != nil {
+		t.Errorf("directory not replaced: %v", err)
+	}
+	if n := nlink(t, fs, "/"); n != 4 {
+		t.Errorf("wrong link count for / %d", n)
+	}
+	if n := nlink(t, fs, "/a"); n != 2 {
+		t.Errorf("wrong link count for /a %d", n)
+	}
+
+	// A file is replaced.
+	err = fs.Rename("/a/file", "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/file"); s != "a" {
+		t.Errorf("file not replaced: %q", s)
+	}
+}
+
+func TestRenameWithFlags(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/x/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/y", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/x/one", "one")
+	writeFile(t, fs, "/y/two", "two")
+
+	err = fs.RenameWithFlags("/x/one", "/y/two", memfs.RENAME_NOREPLACE)
+	if !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("expected EEXIST, got %v", err)
+	}
+	err = fs.RenameWithFlags("/x/one", "/y/three", memfs.RENAME_NOREPLACE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.RenameWithFlags("/y/three", "/x/one", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.RenameWithFlags("/x/one", "/y/two", memfs.RENAME_EXCHANGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, fs, "/x/one"); s != "two" {
+		t.Errorf("files not exchanged: %q", s)
+	}
+	if s := readFile(t, fs, "/y/two"); s != "one" {
+		t.Errorf("files not exchanged: %q", s)
+	}
+
+	// Exchange a directory with a file in another directory.
+	err = fs.RenameWithFlags("/x/sub", "/y/two", memfs.RENAME_EXCHANGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fs.Stat("/y/two"); err != nil || !info.IsDir() {
+		t.Fatalf("directory not exchanged: %v", err)
+	}
+	if s := readFile(t, fs, "/x/sub"); s != "one" {
+		t.Errorf("file not exchanged: %q", s)
+	}
+	err = fs.Mkdir("/y/two/../marker", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/y/marker"); err != nil {
+		t.Errorf("wrong parent after exchange: %v", err)
+	}
+	if n := nlink(t, fs, "/x"); n != 2 {
+		t.Errorf("wrong link count for /x %d", n)
+	}
+	if n := nlink(t, fs, "/y"); n != 4 {
+		t.Errorf("wrong link count for /y %d", n)
+	}
+
+	tests := []struct {
+		old, new string
+		flags    uint
+		err      error
+	}{
+		{"/x/one", "/y/missing", memfs.RENAME_EXCHANGE, syscall.ENOENT},
+		{"/y", "/y/two", memfs.RENAME_EXCHANGE, syscall.EINVAL},
+		{"/y/two", "/y", memfs.RENAME_EXCHANGE, syscall.EINVAL},
+		{"/x/one", "/y/two", memfs.RENAME_EXCHANGE | memfs.RENAME_NOREPLACE, syscall.EINVAL},
+		{"/x/one", "/y/two", 0x100, syscall.EINVAL},
+	}
+	for _, test := range tests {
+		err := fs.RenameWithFlags(test.old, test.new, test.flags)
+		if !errors.Is(err, test.err) {
+			t.Errorf("rename %s %s %#x: expected %v, got %v", test.old, test.new, test.flags, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package memfs
+
+import (
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"strings"
+	"syscall"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/inode"
+)
+
+// maxSymlinks is the maximum number of symbolic links followed while resolving
+// a path, the same limit Linux enforces before returning ELOOP.
+const maxSymlinks = 40
+
+// O_NOFOLLOW may be or'ed into the flags passed to OpenFile to fail with ELOOP
+// instead of following a final symbolic link. The value matches Linux.
+const O_NOFOLLOW = 0x20000
+
+// location is the result of resolving a path.
+type location struct {
+	parent *inode.Inode // directory containing the final path element.
+	name   string       // final path element.
+	node   *inode.Inode // inode named by the path, nil if it does not exist.
+
+	// For paths inside a mounted filesystem, the other fields are unset and
+	// the path is rest in the filesystem mounted by mount, relative to its
+	// working directory. rest is "." for the root of the mount.
+	mount *mount
+	rest  string
+}
+
+// walk resolves name relative to the current working directory. See walkFrom.
+func (fs *FileSystem) walk(name string, follow bool) (location, error) {
+	return fs.walkFrom(fs.dir, fs.relative(name), follow, 0)
+}
+
+// relative returns name as a path relative to fs.dir. It differs from name
+// only if the working directory is inside a mounted filesystem.
+func (fs *FileSystem) relative(name string) string {
+	if fs.wd == "" || name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return fs.wd + "/" + name
+}
+
+// walkFrom resolves name one element at a time, starting at dir for relative
+// paths and at the root for absolute paths. Symbolic links are followed in
+// every element but the last, relative to the directory containing the link;
+// the last element is followed only if follow is true or name ends in a slash.
+// The final element does not need to exist, in which case the returned
+// location has a nil node. Paths reaching a directory with a filesystem
+// mounted on it continue in the mounted filesystem, unless how, a combination
+// of RESOLVE_* flags, is non-zero, in which case they fail with EXDEV. Errors
+// are returned unwrapped so callers can add their own Op.
+func (fs *FileSystem) walkFrom(dir *inode.Inode, name string, follow bool, how int) (location, error) {
+	beneath := how&RESOLVE_BENEATH != 0
+	if name == "" {
+		return location{}, syscall.ENOENT
+	}
+	if filepath.IsAbs(name) {
+		if beneath {
+			return location{}, syscall.EXDEV
+		}
+		dir = fs.root
+	}
+	trailing := strings.HasSuffix(name, "/")
+	// a final "." names the directory itself, and is kept in the location so
+	// that callers can reject it
+	dot := filepath.Base(name) == "."
+	follow = follow || trailing || dot
+
+	elems := splitPath(name)
+	hops := 0
+	depth := 0 // directories below the starting directory, for RESOLVE_BENEATH
+	for len(elems) > 0 {
+		if m := fs.mounted(dir); m != nil {
+			if how != 0 {
+				return location{}, syscall.EXDEV
+			}
+			inner := elems
+			if dot {
+				inner = append(elems[:len(elems):len(elems)], ".")
+			}
+			loc, rest, ok := m.enter(inner, trailing)
+			if ok {
+				return loc, nil
+			}
+			// ".." left the mounted filesystem
+			if dir != fs.root {
+				dir = entry(dir, "..")
+			}
+			elems = rest
+			continue
+		}
+
+		elem := elems[0]
+		elems = elems[1:]
+		last := len(elems) == 0
+
+		if !dir.IsDir() {
+			return location{}, syscall.ENOTDIR
+		}
+		if !fs.access(dir, absfs.OS_EX) {
+			return location{}, syscall.EACCES
+		}
+
+		var node *inode.Inode
+		switch {
+		case elem == "..":
+			if beneath {
+				if depth == 0 {
+					return location{}, syscall.EXDEV
+				}
+				depth--
+			}
+			node = dir
+			if dir != fs.root {
+				node = entry(dir, "..")
+			}
+		default:
+			node = entry(dir, elem)
+		}
+
+		if node == nil {
+			// nothing can be created in a removed directory
+			if !last || dot || dir.Nlink == 0 {
+				return location{}, syscall.ENOENT
+			}
+			return location{parent: dir, name: elem}, nil
+		}
+
+		if node.Mode&os.ModeSymlink != 0 && (!last || follow) {
+			if how&RESOLVE_NO_SYMLINKS != 0 {
+				return location{}, syscall.ELOOP
+			}
+			hops++
+			if hops > maxSymlinks {
+				return location{}, syscall.ELOOP
+			}
+			target := fs.symlinks[node.Ino]
+			if target == "" {
+				return location{}, syscall.ENOENT
+			}
+			if filepath.IsAbs(target) {
+				if beneath {
+					return location{}, syscall.EXDEV
+				}
+				dir = fs.root
+			}
+			elems = append(splitPath(target), elems...)
+			continue
+		}
+
+		if last {
+			if (trailing || dot) && !node.IsDir() {
+				return location{}, syscall.ENOTDIR
+			}
+			if m := fs.mounted(node); m != nil {
+				if how != 0 {
+					return location{}, syscall.EXDEV
+				}
+				return location{mount: m, rest: "."}, nil
+			}
+			if dot {
+				return location{parent: node, name: ".", node: node}, nil
+			}
+			return location{parent: dir, name: elem, node: node}, nil
+		}
+		if elem != ".." {
+			depth++
+		}
+		dir = node
+	}
+
+	// the path, or the target of its last symbolic link, named dir itself
+	if m := fs.mounted(dir); m != nil {
+		if how != 0 {
+			return location{}, syscall.EXDEV
+		}
+		return location{mount: m, rest: "."}, nil
+	}
+	return location{parent: dir, name: ".", node: dir}, nil
+}
+
+// splitPath returns the elements of path, omitting empty and "." elements.
+func splitPath(path string) []string {
+	var elems []string
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		elems = append(elems, elem)
+	}
+	return elems
+}
+
+// lookup is like walk but fails with ENOENT if name does not exist.
+func (fs *FileSystem) lookup(name string, follow bool) (location, error) {
+	loc, err := fs.walk(name, follow)
+	if err == nil && loc.mount == nil && loc.node == nil {
+		err = syscall.ENOENT
+	}
+	return loc, err
+}
+
+// resolve returns the inode for name, following a final symbolic link if follow
+// is true. It fails with EOPNOTSUPP for paths inside mounted filesystems, which
+// have no inode. Errors are returned unwrapped so callers can add their own Op.
+func (fs *FileSystem) resolve(name string, follow bool) (*inode.Inode, error) {
+	loc, err := fs.lookup(name, follow)
+	if err != nil {
+		return nil, err
+	}
+	if loc.mount != nil {
+		return nil, syscall.EOPNOTSUPP
+	}
+	return loc.node, nil
+}
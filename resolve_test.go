@@ -0,0 +1,405 @@
+package memfs_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestSymlinkLoop(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Create a cycle a -> b -> a.
+	err = fs.Symlink("/target", "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("/a", "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Remove("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("/b", "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkLoop := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("%s: expected ELOOP, got %v", op, err)
+		}
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("%s: expected *os.PathError, got %T", op, err)
+		}
+	}
+
+	_, err = fs.Stat("/a")
+	checkLoop("stat", err)
+	_, err = fs.Open("/a")
+	checkLoop("open", err)
+	_, err = fs.OpenFile("/a", os.O_CREATE|os.O_RDWR, 0644)
+	checkLoop("open create", err)
+	err = fs.Chdir("/a")
+	checkLoop("chdir", err)
+
+	if _, err := fs.Lstat("/a"); err != nil {
+		t.Errorf("lstat: %v", err)
+	}
+}
+
+func TestSymlinkHopLimit(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/link0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	for i := 1; i <= 41; i++ {
+		err = fs.Symlink(fmt.Sprintf("link%d", i-1), fmt.Sprintf("/link%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := fs.Stat("/link40"); err != nil {
+		t.Errorf("40 links should resolve: %v", err)
+	}
+	if _, err := fs.Stat("/link41"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("expected ELOOP, got %v", err)
+	}
+}
+
+func TestIntermediateSymlinks(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/real/dir", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/real/dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("content"))
+	f.Close()
+
+	err = fs.Symlink("/real/dir", "/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/a", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Chdir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("../real/dir", "/a/rel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Chdir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/abs/file.txt", "/a/rel/file.txt", "/a/rel/../dir/file.txt", "abs/file.txt"} {
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Errorf("stat %s: %v", name, err)
+			continue
+		}
+		if info.Size() != 7 {
+			t.Errorf("stat %s: wrong size %d", name, info.Size())
+		}
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Errorf("open %s: %v", name, err)
+			continue
+		}
+		f.Close()
+	}
+
+	f, err = fs.Create("/abs/new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := fs.Stat("/real/dir/new.txt"); err != nil {
+		t.Errorf("file not created through the link: %v", err)
+	}
+
+	err = fs.Mkdir("/a/rel/sub", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fs.Stat("/real/dir/sub"); err != nil || !info.IsDir() {
+		t.Errorf("directory not created through the link: %v", err)
+	}
+	err = fs.Remove("/abs/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/real/dir/sub"); !os.IsNotExist(err) {
+		t.Errorf("directory not removed through the link: %v", err)
+	}
+
+	err = fs.Chdir("/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("file.txt"); err != nil {
+		t.Errorf("relative stat after chdir through a link: %v", err)
+	}
+	err = fs.Chdir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The final element is only followed on request.
+	info, err := fs.Lstat("/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat followed the link: %s", info.Mode())
+	}
+	info, err = fs.Lstat("/abs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("trailing slash did not follow the link: %s", info.Mode())
+	}
+	_, err = fs.OpenFile("/abs", os.O_RDONLY|memfs.O_NOFOLLOW, 0)
+	if !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("expected ELOOP with O_NOFOLLOW, got %v", err)
+	}
+
+	_, err = fs.Stat("/real/dir/file.txt/x")
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+	_, err = fs.Stat("/missing/file.txt")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+}
+
+func nlink(t *testing.T, fs *memfs.FileSystem, name string) uint64 {
+	t.Helper()
+	info, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := memfs.StatOf(info)
+	return stat.Nlink
+}
+
+func TestRemoveDir(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/a/b/c", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := nlink(t, fs, "/a"); n != 3 {
+		t.Errorf("wrong link count %d", n)
+	}
+
+	err = fs.Remove("/a/b")
+	if !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("expected ENOTEMPTY, got %v", err)
+	}
+	err = fs.Remove("/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a final "." names the directory, which cannot be removed or renamed
+	// through it
+	for _, name := range []string{"/a/b/.", "/a/b/./"} {
+		if err := fs.Remove(name); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("remove %s: expected EINVAL, got %v", name, err)
+		}
+		if err := fs.RemoveAll(name); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("remove all %s: expected EINVAL, got %v", name, err)
+		}
+		if err := fs.Rename(name, "/a/z"); !errors.Is(err, syscall.EBUSY) {
+			t.Errorf("rename %s: expected EBUSY, got %v", name, err)
+		}
+	}
+	if _, err := fs.Stat("/a/b"); err != nil {
+		t.Errorf("directory removed through .: %v", err)
+	}
+	if err := fs.Mkdir("/a/missing/.", 0755); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("mkdir /a/missing/.: expected ENOENT, got %v", err)
+	}
+
+	err = fs.Remove("/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := nlink(t, fs, "/a"); n != 2 {
+		t.Errorf("wrong link count after remove %d", n)
+	}
+
+	err = fs.MkdirAll("/a/x/y", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.RemoveAll("/a/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := nlink(t, fs, "/a"); n != 2 {
+		t.Errorf("wrong link count after remove all %d", n)
+	}
+	if err := fs.RemoveAll("/a/missing"); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+}
+
+func TestDanglingSymlink(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Mkdir("/dir", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("target.txt", "/dir/link")
+	if err != nil {
+		t.Fatalf("dangling symlink: %v", err)
+	}
+	info, err := fs.Lstat("/dir/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != os.ModeSymlink|0777 {
+		t.Errorf("wrong mode %s", info.Mode())
+	}
+	if info.Size() != int64(len("target.txt")) {
+		t.Errorf("wrong size %d", info.Size())
+	}
+	if _, err := fs.Stat("/dir/link"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	// The relative target is resolved against /dir, not the working directory.
+	f, err := fs.Create("/dir/target.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("data"))
+	f.Close()
+	info, err = fs.Stat("/dir/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 4 || !info.Mode().IsRegular() {
+		t.Errorf("link resolved to the wrong file: %s %d", info.Mode(), info.Size())
+	}
+
+	// Opening a dangling link with O_CREATE creates its target.
+	err = fs.Symlink("/dir/created.txt", "/create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = fs.OpenFile("/create", os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := fs.Lstat("/dir/created.txt"); err != nil {
+		t.Errorf("target not created: %v", err)
+	}
+
+	err = fs.Symlink("/elsewhere", "/dir/link")
+	if !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("expected EEXIST, got %v", err)
+	}
+	if _, ok := err.(*os.LinkError); !ok {
+		t.Errorf("expected *os.LinkError, got %T", err)
+	}
+	target, err := fs.Readlink("/dir/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "target.txt" {
+		t.Errorf("link was overwritten: %q", target)
+	}
+
+	_, err = fs.Readlink("/dir/target.txt")
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("expected EINVAL, got %v", err)
+	}
+	_, err = fs.Readlink("/dir/missing")
+	if !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("expected ENOENT, got %v", err)
+	}
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll("/d/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for target, name := range map[string]string{
+		"missing": "/d/dangling",
+		"/d":      "/d/up",
+		"sub":     "/d/sub-link",
+	} {
+		if err := fs.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var walked []string
+	err = fs.Walk("/d", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path+" "+info.Mode().Type().String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/d d---------", "/d/dangling L---------", "/d/sub d---------", "/d/sub-link L---------", "/d/up L---------"}
+	if strings.Join(walked, "\n") != strings.Join(want, "\n") {
+		t.Errorf("walked\n%s\nwant\n%s", strings.Join(walked, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,121 @@
+package memfs
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/absfs/inode"
+)
+
+// Stat is a snapshot of the metadata of a file, modeled after stat(2) with the
+// addition of the birth time. Modifying a Stat has no effect on the file.
+type Stat struct {
+	Dev     uint64      // id of the FileSystem containing the file.
+	Ino     uint64      // inode number.
+	Nlink   uint64      // number of hard links.
+	Mode    os.FileMode // file mode bits.
+	Uid     uint32      // user id of the owner.
+	Gid     uint32      // group id of the owner.
+	Rdev    uint64      // device id, always 0; the only device files are overlay whiteouts.
+	Size    int64       // length in bytes.
+	Blksize int64       // preferred block size for I/O.
+	Blocks  int64       // number of 512 byte blocks allocated.
+
+	Atime time.Time // time of last access.
+	Mtime time.Time // time of last modification.
+	Ctime time.Time // time of last status change.
+	Btime time.Time // time of creation.
+}
+
+// blockSize is the block size memfs reports for all files.
+const blockSize = 4096
+
+// Unix file type and mode bits as used in the st_mode field of stat(2).
+const (
+	S_IFMT   = 0170000
+	S_IFSOCK = 0140000
+	S_IFLNK  = 0120000
+	S_IFREG  = 0100000
+	S_IFBLK  = 0060000
+	S_IFDIR  = 0040000
+	S_IFCHR  = 0020000
+	S_IFIFO  = 0010000
+	S_ISUID  = 0004000
+	S_ISGID  = 0002000
+	S_ISVTX  = 0001000
+)
+
+// UnixMode returns the mode in the form of the st_mode field of stat(2).
+func (s *Stat) UnixMode() uint32 {
+	m := s.Mode
+	mode := uint32(m.Perm())
+	switch {
+	case m&os.ModeDir != 0:
+		mode |= S_IFDIR
+	case m&os.ModeSymlink != 0:
+		mode |= S_IFLNK
+	case m&os.ModeNamedPipe != 0:
+		mode |= S_IFIFO
+	case m&os.ModeSocket != 0:
+		mode |= S_IFSOCK
+	case m&os.ModeCharDevice != 0:
+		mode |= S_IFCHR
+	case m&os.ModeDevice != 0:
+		mode |= S_IFBLK
+	default:
+		mode |= S_IFREG
+	}
+	if m&os.ModeSetuid != 0 {
+		mode |= S_ISUID
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= S_ISGID
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= S_ISVTX
+	}
+	return mode
+}
+
+// StatOf returns the Stat snapshot of a FileInfo returned by memfs, and false
+// if info was not returned by memfs.
+func StatOf(info os.FileInfo) (*Stat, bool) {
+	fi, ok := info.(*fileinfo)
+	if !ok {
+		return nil, false
+	}
+	stat := fi.stat
+	return &stat, true
+}
+
+// lastDev is the device id most recently assigned to a FileSystem.
+var lastDev uint64
+
+func nextDev() uint64 {
+	return atomic.AddUint64(&lastDev, 1)
+}
+
+// stat returns a Stat snapshot of node.
+func (fs *FileSystem) stat(node *inode.Inode) Stat {
+	return Stat{
+		Dev:     fs.dev,
+		Ino:     node.Ino,
+		Nlink:   node.Nlink,
+		Mode:    node.Mode,
+		Uid:     node.Uid,
+		Gid:     node.Gid,
+		Size:    node.Size,
+		Blksize: blockSize,
+		Blocks:  (node.Size + blockSize - 1) / blockSize * (blockSize / 512),
+		Atime:   node.Atime,
+		Mtime:   node.Mtime,
+		Ctime:   node.Ctime,
+		Btime:   fs.btimes[node.Ino],
+	}
+}
+
+// fileinfo returns an os.FileInfo describing node under the given name.
+func (fs *FileSystem) fileinfo(name string, node *inode.Inode) *fileinfo {
+	return &fileinfo{name: name, stat: fs.stat(node)}
+}
@@ -0,0 +1,55 @@
+package memfs
+
+import (
+	"syscall"
+)
+
+// StatT converts s to a *syscall.Stat_t. The birth time has no equivalent
+// field and is dropped.
+func (s *Stat) StatT() *syscall.Stat_t {
+	st := new(syscall.Stat_t)
+	setUint(&st.Dev, s.Dev)
+	st.Ino = s.Ino
+	setUint(&st.Nlink, s.Nlink)
+	st.Mode = s.UnixMode()
+	st.Uid = s.Uid
+	st.Gid = s.Gid
+	setUint(&st.Rdev, s.Rdev)
+	st.Size = s.Size
+	setInt(&st.Blksize, s.Blksize)
+	st.Blocks = s.Blocks
+	st.Atim = syscall.NsecToTimespec(s.Atime.UnixNano())
+	st.Mtim = syscall.NsecToTimespec(s.Mtime.UnixNano())
+	st.Ctim = syscall.NsecToTimespec(s.Ctime.UnixNano())
+	return st
+}
+
+// sys returns the value returned by FileInfo.Sys, a *syscall.Stat_t on Linux.
+func (s *Stat) sys() interface{} {
+	return s.StatT()
+}
+
+// statInt is the set of types of the Stat_t fields whose width or signedness
+// varies between architectures, such as Blksize, which is a uint32 on mips64.
+type statInt interface {
+	~int32 | ~int64 | ~uint32 | ~uint64
+}
+
+// setUint and setInt assign to Stat_t fields of any of the statInt types.
+func setUint[T statInt](dst *T, v uint64) {
+	*dst = T(v)
+}
+
+func setInt[T statInt](dst *T, v int64) {
+	*dst = T(v)
+}
+
+// sysOwner returns the owner recorded in the value returned by FileInfo.Sys,
+// and false if it is not a *syscall.Stat_t.
+func sysOwner(sys interface{}) (uid, gid uint32, ok bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
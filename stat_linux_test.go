@@ -0,0 +1,48 @@
+package memfs_test
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestSysStatT(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs, err := memfs.NewFSWithClock(memfs.NewFakeClock(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+	err = fs.Chown("/file.txt", 1000, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		t.Fatalf("Sys() returned %T", info.Sys())
+	}
+	if st.Mode != syscall.S_IFREG|0644 || st.Uid != 1000 || st.Gid != 100 || st.Size != 5 || st.Nlink != 1 || st.Blocks != 8 {
+		t.Errorf("wrong Stat_t: %+v", st)
+	}
+	if st.Mtim.Nano() != start.UnixNano() {
+		t.Errorf("wrong mtime %v", st.Mtim)
+	}
+
+	// Changing the returned value does not affect the file.
+	st.Size = 0
+	if info.Sys().(*syscall.Stat_t).Size != 5 {
+		t.Error("Sys() exposes shared state")
+	}
+}
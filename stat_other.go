@@ -0,0 +1,15 @@
+//go:build !linux
+
+package memfs
+
+// sys returns the value returned by FileInfo.Sys, a *Stat on platforms other
+// than Linux.
+func (s *Stat) sys() interface{} {
+	return s
+}
+
+// sysOwner returns the owner recorded in the value returned by FileInfo.Sys.
+// Only memfs FileInfos report owners on platforms other than Linux.
+func sysOwner(sys interface{}) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
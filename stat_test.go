@@ -0,0 +1,87 @@
+package memfs_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+func TestStatOf(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := memfs.NewFakeClock(start)
+	fs, err := memfs.NewFSWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/dir", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	f.Write(make([]byte, 5000))
+	f.Close()
+
+	info, err := fs.Stat("/dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, ok := memfs.StatOf(info)
+	if !ok {
+		t.Fatal("not a memfs FileInfo")
+	}
+	if stat.Nlink != 1 || stat.Size != 5000 || stat.Blocks != 16 || stat.Blksize != 4096 {
+		t.Errorf("wrong stat: %+v", stat)
+	}
+	if !stat.Btime.Equal(start) || !stat.Mtime.Equal(start.Add(time.Hour)) {
+		t.Errorf("wrong times: btime %s, mtime %s", stat.Btime, stat.Mtime)
+	}
+	if stat.UnixMode() != memfs.S_IFREG|0644 {
+		t.Errorf("wrong unix mode %o", stat.UnixMode())
+	}
+
+	// The snapshot is not affected by later changes.
+	stat.Size = 0
+	err = fs.Chmod("/dir/file.txt", 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5000 || info.Mode() != 0644 {
+		t.Errorf("FileInfo changed after the fact: %d %s", info.Size(), info.Mode())
+	}
+
+	dir, err := fs.Stat("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstat, _ := memfs.StatOf(dir)
+	if dstat.Nlink != 2 || dstat.UnixMode() != memfs.S_IFDIR|0755 {
+		t.Errorf("wrong directory stat: %+v", dstat)
+	}
+	if dstat.Dev != stat.Dev || dstat.Ino == stat.Ino {
+		t.Errorf("wrong device or inode numbers: %+v %+v", dstat, stat)
+	}
+
+	other, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := other.Stat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rstat, _ := memfs.StatOf(root); rstat.Dev == stat.Dev {
+		t.Errorf("file systems share device id %d", rstat.Dev)
+	}
+
+	if _, ok := memfs.StatOf(fakeInfo{}); ok {
+		t.Error("StatOf accepted a foreign FileInfo")
+	}
+}
+
+type fakeInfo struct{ os.FileInfo }
@@ -0,0 +1,46 @@
+package memfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// Sub returns a FileSystem whose root is the directory dir of fs. The two
+// share the same inodes and contents, so changes made through one are visible
+// through the other, but paths resolved through the returned FileSystem are
+// confined to dir: ".." at its root stays at its root, and absolute symbolic
+// link targets are resolved against it. Its working directory starts at its
+// root, and its other settings are copied from fs as by View.
+func (fs *FileSystem) Sub(dir string) (*FileSystem, error) {
+	fs.mu.RLock()
+	node, err := fs.resolve(dir, true)
+	fs.mu.RUnlock()
+	if err != nil {
+		return nil, &os.PathError{Op: "sub", Path: dir, Err: err}
+	}
+	if !node.IsDir() {
+		return nil, &os.PathError{Op: "sub", Path: dir, Err: syscall.ENOTDIR}
+	}
+
+	sub := fs.View()
+	sub.root = node
+	sub.dir = node
+	sub.wd = ""
+	return sub, nil
+}
+
+// View returns a FileSystem that shares the inodes, contents and root of fs
+// but has its own working directory, umask, credentials, atime policy and file
+// size limit, initially copied from fs. Chdir and changes to the settings of a
+// view do not affect fs or other views. The working directory refers to a
+// directory, not a path, so it is not affected when the directory is renamed.
+//
+// Every operation locks the shared tree, so views may be used from different
+// goroutines at the same time. A single FileSystem may be too, as long as
+// Chdir and changes to its settings do not run concurrently with its other
+// operations.
+func (fs *FileSystem) View() *FileSystem {
+	view := *fs
+	view.Groups = append([]int(nil), fs.Groups...)
+	return &view
+}
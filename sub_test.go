@@ -0,0 +1,279 @@
+package memfs_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+func TestSub(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/fixture/dir", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/fixture/dir/file", "inside")
+	writeFile(t, fs, "/secret", "outside")
+	writeFile(t, fs, "/fixture/secret", "jailed")
+
+	sub, err := fs.Sub("/fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ absfs.SymlinkFileSystem = sub
+
+	if s := readFile(t, sub, "/dir/file"); s != "inside" {
+		t.Errorf("wrong content %q", s)
+	}
+	if dir, _ := sub.Getwd(); dir != "/" {
+		t.Errorf("wrong working directory %q", dir)
+	}
+
+	// Changes are shared in both directions.
+	writeFile(t, sub, "/dir/new", "from sub")
+	if s := readFile(t, fs, "/fixture/dir/new"); s != "from sub" {
+		t.Errorf("change not visible in parent: %q", s)
+	}
+	writeFile(t, fs, "/fixture/dir/file", "from parent")
+	if s := readFile(t, sub, "dir/file"); s != "from parent" {
+		t.Errorf("change not visible in sub: %q", s)
+	}
+
+	// Nothing resolves outside the sub-root.
+	err = sub.Symlink("/secret", "/dir/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sub.Symlink("../../../secret", "/dir/rel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/../secret", "dir/../../secret", "/dir/abs", "/dir/rel"} {
+		if s := readFile(t, sub, name); s != "jailed" {
+			t.Errorf("%s escaped the sub-root: %q", name, s)
+		}
+	}
+	if s := readFile(t, fs, "/fixture/dir/abs"); s != "outside" {
+		t.Errorf("absolute link resolved in the sub-root from the parent: %q", s)
+	}
+
+	err = sub.Remove("/")
+	if !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected EBUSY, got %v", err)
+	}
+	err = sub.Rename("/", "/x")
+	if !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected EBUSY, got %v", err)
+	}
+
+	// A sub of a sub is confined to the inner directory.
+	inner, err := sub.Sub("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inner.Stat("/file"); err != nil {
+		t.Errorf("stat in nested sub: %v", err)
+	}
+	if _, err := inner.Stat("/dir"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	_, err = fs.Sub("/secret")
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+	_, err = fs.Sub("/missing")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+}
+
+func TestView(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/a/dir", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.MkdirAll("/b", 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, v2 := fs.View(), fs.View()
+	err = v1.Chdir("/a/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v2.Chdir("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2.Umask = 0700
+	writeFile(t, v1, "file", "one")
+	writeFile(t, v2, "file", "two")
+
+	if s := readFile(t, fs, "/a/dir/file"); s != "one" {
+		t.Errorf("wrong content %q", s)
+	}
+	if s := readFile(t, fs, "/b/file"); s != "two" {
+		t.Errorf("wrong content %q", s)
+	}
+	if dir, _ := fs.Getwd(); dir != "/" {
+		t.Errorf("view changed the parent working directory: %q", dir)
+	}
+	info, err := fs.Stat("/b/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("view umask not applied: %s", info.Mode())
+	}
+	info, err = fs.Stat("/a/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("view umask leaked: %s", info.Mode())
+	}
+
+	// The working directory tracks the directory through renames.
+	err = fs.Rename("/a", "/renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := v1.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/renamed/dir" {
+		t.Errorf("wrong working directory %q", dir)
+	}
+	if s := readFile(t, v1, "file"); s != "one" {
+		t.Errorf("wrong content %q", s)
+	}
+
+	err = fs.Remove("/b/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Remove("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v2.Getwd()
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	// A symbolic link in the path is not part of the working directory.
+	err = fs.Symlink("/renamed/dir", "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v2.Chdir("/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, _ := v2.Getwd(); dir != "/renamed/dir" {
+		t.Errorf("wrong working directory %q", dir)
+	}
+}
+
+// TestViewConcurrent uses views of one tree from several goroutines at once.
+// Run it with -race.
+func TestViewConcurrent(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/shared", "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := useView(fs.View(), i); err != nil {
+				t.Errorf("view %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	info, err := fs.Stat("/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 8 {
+		t.Errorf("wrong size of shared file: %d", info.Size())
+	}
+	if report := fs.Check(); !report.OK() {
+		t.Error(report)
+	}
+}
+
+// useView works in a directory of its own through the relative paths of v and
+// writes byte i of the file /shared.
+func useView(v *memfs.FileSystem, i int) error {
+	dir := fmt.Sprintf("/view%d", i)
+	if err := v.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	if err := v.Chdir(dir); err != nil {
+		return err
+	}
+	for j := 0; j < 50; j++ {
+		f, err := v.Create("file")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte("data"))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if err := v.Setxattr("file", "user.view", []byte(dir), 0); err != nil {
+			return err
+		}
+		if err := v.Rename("file", "moved"); err != nil {
+			return err
+		}
+		d, err := v.Open(".")
+		if err != nil {
+			return err
+		}
+		names, err := d.Readdirnames(-1)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(names) != "[. .. moved]" {
+			return fmt.Errorf("wrong entries %q", names)
+		}
+		if err := v.Remove("moved"); err != nil {
+			return err
+		}
+	}
+
+	f, err := v.OpenFile("/shared", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteAt([]byte{'a' + byte(i)}, int64(i))
+	return err
+}
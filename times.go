@@ -0,0 +1,237 @@
+package memfs
+
+import (
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/absfs/inode"
+)
+
+// AtimePolicy controls when reading a file or directory updates its access
+// time, mirroring the Linux mount options of the same names.
+type AtimePolicy int
+
+const (
+	// Relatime updates the access time only if it is older than the
+	// modification or change time, or more than a day old. This is the Linux
+	// default.
+	Relatime AtimePolicy = iota
+
+	// StrictAtime updates the access time on every access.
+	StrictAtime
+
+	// Noatime never updates the access time.
+	Noatime
+)
+
+// relatimeInterval is the maximum age of an access time under Relatime.
+const relatimeInterval = 24 * time.Hour
+
+func (fs *FileSystem) now() time.Time {
+	return fs.clock.Now()
+}
+
+// stamp sets all timestamps, including the birth time, of a newly allocated
+// node to the current time.
+func (fs *FileSystem) stamp(node *inode.Inode) {
+	now := fs.now()
+	node.Atime = now
+	node.Mtime = now
+	node.Ctime = now
+	fs.btimes[node.Ino] = now
+}
+
+// accessed marks node as read, according to the atime policy.
+func (fs *FileSystem) accessed(node *inode.Inode) {
+	if fs.rdonly.Load() {
+		return
+	}
+	now := fs.now()
+	switch fs.AtimePolicy {
+	case Noatime:
+		return
+	case Relatime:
+		if node.Atime.After(node.Mtime) && node.Atime.After(node.Ctime) && now.Sub(node.Atime) < relatimeInterval {
+			return
+		}
+	}
+	node.Atime = now
+}
+
+// modified marks the content of node as changed.
+func (fs *FileSystem) modified(node *inode.Inode) {
+	now := fs.now()
+	node.Mtime = now
+	node.Ctime = now
+}
+
+// changed marks the metadata of node as changed.
+func (fs *FileSystem) changed(node *inode.Inode) {
+	node.Ctime = fs.now()
+}
+
+// atimes records access times so they can be restored after calling into the
+// inode package, which updates them as a side effect of linking and unlinking.
+type atimes map[*inode.Inode]time.Time
+
+func saveAtimes(nodes ...*inode.Inode) atimes {
+	saved := make(atimes, len(nodes))
+	for _, n := range nodes {
+		if n != nil {
+			saved[n] = n.Atime
+		}
+	}
+	return saved
+}
+
+func (a atimes) restore() {
+	for n, t := range a {
+		n.Atime = t
+	}
+}
+
+// entry returns the inode linked as name in dir, or nil.
+func entry(dir *inode.Inode, name string) *inode.Inode {
+	x := sort.Search(len(dir.Dir), func(i int) bool {
+		return dir.Dir[i].Name >= name
+	})
+	if x < len(dir.Dir) && dir.Dir[x].Name == name {
+		return dir.Dir[x].Inode
+	}
+	return nil
+}
+
+// link adds the directory entry name for child to parent, updating the
+// modification time of parent and the change times of child and any inode the
+// entry replaces. It fails with ENOENT if parent has been removed.
+func (fs *FileSystem) link(parent *inode.Inode, name string, child *inode.Inode) error {
+	if parent.Nlink == 0 {
+		return syscall.ENOENT
+	}
+	replaced := entry(parent, name)
+	saved := saveAtimes(parent, child, replaced)
+	err := parent.Link(name, child)
+	saved.restore()
+	if err != nil {
+		return err
+	}
+	fs.modified(parent)
+	fs.changed(child)
+	if replaced != nil {
+		fs.changed(replaced)
+	}
+	return nil
+}
+
+// unlink removes the directory entry name from parent, updating the
+// modification time of parent and the change time of the unlinked inode, and
+// releases the inode if that was its last link.
+func (fs *FileSystem) unlink(parent *inode.Inode, name string) error {
+	child := entry(parent, name)
+	saved := saveAtimes(parent, child)
+	err := parent.Unlink(name)
+	saved.restore()
+	if err != nil {
+		return err
+	}
+	fs.modified(parent)
+	if child != nil {
+		fs.changed(child)
+		fs.release(child)
+	}
+	return nil
+}
+
+// rmdir removes the empty directory dir, linked as name in parent, dropping
+// the links held by its "." and ".." entries.
+func (fs *FileSystem) rmdir(parent *inode.Inode, name string, dir *inode.Inode) error {
+	saved := saveAtimes(parent, dir)
+	dir.Unlink("..")
+	dir.Unlink(".")
+	saved.restore()
+	return fs.unlink(parent, name)
+}
+
+// move relinks the entry oldName in oldParent as newName in newParent,
+// replacing any existing entry, and points the ".." entry of a moved directory
+// at its new parent.
+func (fs *FileSystem) move(oldParent *inode.Inode, oldName string, newParent *inode.Inode, newName string) error {
+	if oldParent == newParent && oldName == newName {
+		return nil
+	}
+	node := entry(oldParent, oldName)
+	if node == nil {
+		return syscall.ENOENT
+	}
+	replaced := entry(newParent, newName)
+	err := fs.link(newParent, newName, node)
+	if err != nil {
+		return err
+	}
+	if replaced != nil {
+		fs.release(replaced)
+	}
+	err = fs.unlink(oldParent, oldName)
+	if err != nil {
+		return err
+	}
+	if node.IsDir() && oldParent != newParent {
+		return fs.link(node, "..", newParent)
+	}
+	return nil
+}
+
+// exchange swaps the entries oldName in oldParent and newName in newParent,
+// relinking the ".." entries of directories that change parents.
+func (fs *FileSystem) exchange(oldParent *inode.Inode, oldName string, newParent *inode.Inode, newName string) error {
+	a, b := entry(oldParent, oldName), entry(newParent, newName)
+	if a == nil || b == nil {
+		return syscall.ENOENT
+	}
+	err := fs.link(oldParent, oldName, b)
+	if err != nil {
+		return err
+	}
+	err = fs.link(newParent, newName, a)
+	if err != nil {
+		return err
+	}
+	if oldParent == newParent {
+		return nil
+	}
+	if a.IsDir() {
+		err = fs.link(a, "..", newParent)
+		if err != nil {
+			return err
+		}
+	}
+	if b.IsDir() {
+		err = fs.link(b, "..", oldParent)
+	}
+	return err
+}
+
+// entryName returns the name under which dir links to node, or "" if dir is
+// nil or has no such entry. "." and ".." are never returned.
+func entryName(dir, node *inode.Inode) string {
+	if dir == nil || dir == node {
+		return ""
+	}
+	for _, e := range dir.Dir {
+		if e.Inode == node && e.Name != "." && e.Name != ".." {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// emptyDir reports whether dir has no entries other than "." and "..".
+func emptyDir(dir *inode.Inode) bool {
+	for _, e := range dir.Dir {
+		if e.Name != "." && e.Name != ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package memfs_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/memfs"
+)
+
+// times returns the access, modification and change times of the named file.
+func times(t *testing.T, fs *memfs.FileSystem, name string) (atime, mtime, ctime time.Time) {
+	t.Helper()
+	info, err := fs.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, ok := memfs.StatOf(info)
+	if !ok {
+		t.Fatalf("%s: not a memfs FileInfo", name)
+	}
+	return stat.Atime, stat.Mtime, stat.Ctime
+}
+
+func TestTimestamps(t *testing.T) {
+	clock := memfs.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tick := func() { clock.Advance(time.Second) }
+	fs, err := memfs.NewFSWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir("/dir", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		_, dirMtime, _ := times(t, fs, "/dir")
+		tick()
+		f, err := fs.Create("/dir/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		_, mtime, ctime := times(t, fs, "/dir")
+		if !mtime.After(dirMtime) || !ctime.After(dirMtime) {
+			t.Errorf("parent times not updated on create")
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		atime, mtime, _ := times(t, fs, "/dir/file.txt")
+		tick()
+		f, err := fs.OpenFile("/dir/file.txt", os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("hello"))
+		f.Close()
+		a, m, c := times(t, fs, "/dir/file.txt")
+		if !m.After(mtime) || !c.Equal(m) {
+			t.Errorf("mtime/ctime not updated on write")
+		}
+		if !a.Equal(atime) {
+			t.Errorf("atime updated on write")
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		_, mtime, _ := times(t, fs, "/dir/file.txt")
+		tick()
+		err := fs.Truncate("/dir/file.txt", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, m, c := times(t, fs, "/dir/file.txt")
+		if !m.After(mtime) || !c.Equal(m) {
+			t.Errorf("mtime/ctime not updated on truncate")
+		}
+		info, err := fs.Stat("/dir/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 2 {
+			t.Errorf("wrong size after truncate: %d", info.Size())
+		}
+	})
+
+	t.Run("chmod", func(t *testing.T) {
+		_, mtime, ctime := times(t, fs, "/dir/file.txt")
+		tick()
+		err := fs.Chmod("/dir/file.txt", 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, m, c := times(t, fs, "/dir/file.txt")
+		if !m.Equal(mtime) {
+			t.Errorf("mtime updated on chmod")
+		}
+		if !c.After(ctime) {
+			t.Errorf("ctime not updated on chmod")
+		}
+	})
+
+	t.Run("chtimes", func(t *testing.T) {
+		_, _, ctime := times(t, fs, "/dir/file.txt")
+		tick()
+		stamp := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+		err := fs.Chtimes("/dir/file.txt", stamp, stamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, m, c := times(t, fs, "/dir/file.txt")
+		if !a.Equal(stamp) || !m.Equal(stamp) {
+			t.Errorf("times not set by chtimes")
+		}
+		if !c.After(ctime) {
+			t.Errorf("ctime not updated on chtimes")
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		err := fs.Mkdir("/other", 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, dirMtime, _ := times(t, fs, "/dir")
+		_, otherMtime, _ := times(t, fs, "/other")
+		_, _, ctime := times(t, fs, "/dir/file.txt")
+		tick()
+		err = fs.Rename("/dir/file.txt", "/other/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, m1, _ := times(t, fs, "/dir")
+		_, m2, _ := times(t, fs, "/other")
+		_, _, c := times(t, fs, "/other/file.txt")
+		if !m1.After(dirMtime) || !m2.After(otherMtime) {
+			t.Errorf("parent mtimes not updated on rename")
+		}
+		if !c.After(ctime) {
+			t.Errorf("ctime not updated on rename")
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		_, mtime, _ := times(t, fs, "/other")
+		tick()
+		err := fs.Remove("/other/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, m, _ := times(t, fs, "/other")
+		if !m.After(mtime) {
+			t.Errorf("parent mtime not updated on remove")
+		}
+	})
+}
+
+func TestAtimePolicy(t *testing.T) {
+	read := func(fs *memfs.FileSystem) {
+		f, err := fs.Open("/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Read(make([]byte, 8))
+		f.Close()
+	}
+
+	for _, policy := range []memfs.AtimePolicy{memfs.Relatime, memfs.StrictAtime, memfs.Noatime} {
+		clock := memfs.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		tick := func() { clock.Advance(time.Second) }
+		fs, err := memfs.NewFSWithClock(clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs.AtimePolicy = policy
+		f, err := fs.Create("/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("data"))
+		f.Close()
+
+		atime, _, _ := times(t, fs, "/file.txt")
+		tick()
+		read(fs)
+		first, _, _ := times(t, fs, "/file.txt")
+		tick()
+		read(fs)
+		second, _, _ := times(t, fs, "/file.txt")
+		clock.Advance(25 * time.Hour)
+		read(fs)
+		third, _, _ := times(t, fs, "/file.txt")
+
+		switch policy {
+		case memfs.Relatime:
+			if !first.After(atime) || !second.Equal(first) || !third.After(second) {
+				t.Errorf("relatime: wrong atime updates")
+			}
+		case memfs.StrictAtime:
+			if !first.After(atime) || !second.After(first) || !third.After(second) {
+				t.Errorf("strictatime: wrong atime updates")
+			}
+		case memfs.Noatime:
+			if !first.Equal(atime) || !second.Equal(atime) || !third.Equal(atime) {
+				t.Errorf("noatime: atime updated")
+			}
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package memfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	filepath "path" // force forward slash separators on all OSs.
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Default modes of the files and directories of a txtar archive.
+const (
+	txtarFileMode = 0644
+	txtarDirMode  = 0755
+)
+
+// FromTxtar returns a new FileSystem containing the files of a txtar archive,
+// the format of golang.org/x/tools/txtar: an optional comment followed by
+// files, each introduced by a marker line of the form "-- name --". Names are
+// relative to the root, and missing parent directories are created.
+//
+// The file names of markers may carry extensions recording what plain txtar
+// cannot:
+//
+//	-- dir/ --               an empty directory.
+//	-- bin/tool mode=0755 -- the permission bits, in octal.
+//	-- link -> target --     a symbolic link to target, with no content.
+//
+// Files and directories without a mode are created with 0644 and 0755. Modes
+// are set once all files exist, so a directory may be read-only. The comment of
+// the archive is ignored.
+func FromTxtar(data []byte) (*FileSystem, error) {
+	fs, err := NewFS()
+	if err != nil {
+		return nil, err
+	}
+	_, files := parseTxtar(data)
+	var created []txtarMode
+	for _, f := range files {
+		m, err := fs.addTxtarFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if m.path != "" {
+			created = append(created, m)
+		}
+	}
+	// the umask does not apply to archives
+	for i := len(created) - 1; i >= 0; i-- {
+		err = fs.Chmod(created[i].path, created[i].mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// txtarMode is the mode of a file created from an archive.
+type txtarMode struct {
+	path string
+	mode os.FileMode
+}
+
+// addTxtarFile creates the file f of an archive with a default mode, and
+// returns the mode it must be given, with an empty path for symbolic links.
+func (fs *FileSystem) addTxtarFile(f txtarFile) (txtarMode, error) {
+	name, mode, hasMode, target, err := parseTxtarName(f.name)
+	if err != nil {
+		return txtarMode{}, err
+	}
+	p := "/" + name
+	dir := filepath.Dir(filepath.Clean(p))
+	err = fs.MkdirAll(dir, txtarDirMode)
+	if err != nil {
+		return txtarMode{}, err
+	}
+
+	switch {
+	case target != "":
+		if len(f.data) > 0 {
+			return txtarMode{}, &os.PathError{Op: "txtar", Path: name, Err: syscall.EINVAL}
+		}
+		return txtarMode{}, fs.Symlink(target, p)
+	case strings.HasSuffix(name, "/"):
+		if !hasMode {
+			mode = txtarDirMode
+		}
+		err = fs.Mkdir(p, txtarDirMode)
+	default:
+		if !hasMode {
+			mode = txtarFileMode
+		}
+		file, ferr := fs.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, txtarFileMode)
+		if ferr != nil {
+			return txtarMode{}, ferr
+		}
+		_, err = file.Write(f.data)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return txtarMode{}, err
+	}
+	return txtarMode{p, mode}, nil
+}
+
+// parseTxtarName splits the name of a marker line into the file name and the
+// mode or link target of the extensions described by FromTxtar. hasMode reports
+// whether a mode was given, as mode=0000 is valid.
+func parseTxtarName(marker string) (name string, mode os.FileMode, hasMode bool, target string, err error) {
+	name = marker
+	if i := strings.Index(name, " -> "); i >= 0 {
+		name, target = name[:i], name[i+len(" -> "):]
+	}
+	if i := strings.LastIndex(name, " mode="); i >= 0 && target == "" {
+		bits, perr := strconv.ParseUint(name[i+len(" mode="):], 8, 32)
+		if perr != nil || bits&^07777 != 0 {
+			return "", 0, false, "", &os.PathError{Op: "txtar", Path: marker, Err: syscall.EINVAL}
+		}
+		name = name[:i]
+		mode, hasMode = fromUnixMode(uint32(bits)), true
+	}
+	if name == "" || name == "/" || filepath.IsAbs(name) {
+		return "", 0, false, "", &os.PathError{Op: "txtar", Path: marker, Err: syscall.EINVAL}
+	}
+	return name, mode, hasMode, target, nil
+}
+
+// fromUnixMode converts the permission and special bits of a st_mode field to
+// an os.FileMode.
+func fromUnixMode(bits uint32) os.FileMode {
+	mode := os.FileMode(bits) & os.ModePerm
+	if bits&S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// ToTxtar returns the files below the directory root as a txtar archive in the
+// format read by FromTxtar, with names relative to root. Modes other than the
+// defaults, symbolic links and empty directories are recorded with the
+// extensions described by FromTxtar. As in txtar, a final newline is added to
+// files that lack one. Files with a line that looks like a marker cannot be
+// archived and fail with EINVAL. ToTxtar reads the tree as root.
+func (fs *FileSystem) ToTxtar(root string) ([]byte, error) {
+	fs = fs.View()
+	fs.Uid, fs.Gid, fs.Groups = 0, 0, nil
+
+	var files []txtarFile
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		infos, err := readdir(fs, filepath.Join(root, rel))
+		if err != nil {
+			return &os.PathError{Op: "txtar", Path: filepath.Join(root, rel), Err: err}
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Name() < infos[j].Name()
+		})
+		for _, info := range infos {
+			if info.Name() == "." || info.Name() == ".." {
+				continue
+			}
+			name := filepath.Join(rel, info.Name())
+			p := filepath.Join(root, name)
+			mode := info.Mode()
+			switch {
+			case mode.IsDir():
+				empty, err := fs.emptyTxtarDir(p)
+				if err != nil {
+					return err
+				}
+				if empty || mode&chmodBits != txtarDirMode {
+					files = append(files, txtarFile{name: txtarName(name+"/", mode, txtarDirMode)})
+				}
+				err = walk(name)
+				if err != nil {
+					return err
+				}
+			case mode&os.ModeSymlink != 0:
+				target, err := fs.Readlink(p)
+				if err != nil {
+					return err
+				}
+				files = append(files, txtarFile{name: name + " -> " + target})
+			case mode.IsRegular():
+				data, err := readAll(fs, p)
+				if err != nil {
+					return err
+				}
+				if len(data) > 0 && data[len(data)-1] != '\n' {
+					data = append(data, '\n')
+				}
+				if hasTxtarMarker(data) {
+					return &os.PathError{Op: "txtar", Path: p, Err: syscall.EINVAL}
+				}
+				files = append(files, txtarFile{name: txtarName(name, mode, txtarFileMode), data: data})
+			default:
+				return &os.PathError{Op: "txtar", Path: p, Err: syscall.EINVAL}
+			}
+		}
+		return nil
+	}
+	err := walk("")
+	if err != nil {
+		return nil, err
+	}
+	return formatTxtar(files), nil
+}
+
+// emptyTxtarDir reports whether the directory name has no entries.
+func (fs *FileSystem) emptyTxtarDir(name string) (bool, error) {
+	infos, err := readdir(fs, name)
+	if err != nil {
+		return false, &os.PathError{Op: "txtar", Path: name, Err: err}
+	}
+	for _, info := range infos {
+		if info.Name() != "." && info.Name() != ".." {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// txtarName returns the marker name of a file, recording its mode if it
+// differs from def.
+func txtarName(name string, mode, def os.FileMode) string {
+	if mode&chmodBits == def {
+		return name
+	}
+	stat := Stat{Mode: mode}
+	return fmt.Sprintf("%s mode=%04o", name, stat.UnixMode()&07777)
+}
+
+// txtarFile is a file of a txtar archive.
+type txtarFile struct {
+	name string
+	data []byte
+}
+
+// parseTxtar returns the comment and files of a txtar archive. A missing
+// final newline of the archive is added to the last file.
+func parseTxtar(data []byte) (comment []byte, files []txtarFile) {
+	current := &comment
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+
+		if name, ok := txtarMarker(line); ok {
+			files = append(files, txtarFile{name: name})
+			current = &files[len(files)-1].data
+			continue
+		}
+		*current = append(*current, line...)
+	}
+	if n := len(*current); n > 0 && (*current)[n-1] != '\n' {
+		*current = append(*current, '\n')
+	}
+	return comment, files
+}
+
+// txtarMarker returns the file name of a "-- name --" marker line.
+func txtarMarker(line []byte) (string, bool) {
+	s := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+	if len(s) < 6 || !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") {
+		return "", false
+	}
+	name := strings.TrimSpace(s[3 : len(s)-3])
+	return name, name != ""
+}
+
+// hasTxtarMarker reports whether data contains a marker line.
+func hasTxtarMarker(data []byte) bool {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+		if _, ok := txtarMarker(line); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTxtar returns files as a txtar archive without a comment.
+func formatTxtar(files []txtarFile) []byte {
+	var b bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&b, "-- %s --\n", f.name)
+		b.Write(f.data)
+	}
+	return b.Bytes()
+}
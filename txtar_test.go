@@ -0,0 +1,159 @@
+package memfs_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+const fixture = `Comments before the first file are ignored.
+-- README --
+hello
+-- bin/tool mode=0755 --
+#!/bin/sh
+-- etc/empty/ --
+-- etc/private/ mode=0700 --
+-- etc/private/key mode=0600 --
+secret
+-- link -> bin/tool --
+-- src/main.go --
+package main
+`
+
+func TestFromTxtar(t *testing.T) {
+	fs, err := memfs.FromTxtar([]byte(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, fs, "/README"); s != "hello\n" {
+		t.Errorf("wrong content %q", s)
+	}
+	if s := readFile(t, fs, "/link"); s != "#!/bin/sh\n" {
+		t.Errorf("wrong content through link %q", s)
+	}
+	modes := map[string]os.FileMode{
+		"/README":          0644,
+		"/bin":             os.ModeDir | 0755,
+		"/bin/tool":        0755,
+		"/etc/empty":       os.ModeDir | 0755,
+		"/etc/private":     os.ModeDir | 0700,
+		"/etc/private/key": 0600,
+		"/link":            os.ModeSymlink | 0777,
+		"/src/main.go":     0644,
+	}
+	for name, mode := range modes {
+		info, err := fs.Lstat(name)
+		if err != nil {
+			t.Errorf("lstat %s: %v", name, err)
+			continue
+		}
+		if info.Mode() != mode {
+			t.Errorf("%s: wrong mode %s, want %s", name, info.Mode(), mode)
+		}
+	}
+
+	// The dump omits the comment but is otherwise identical.
+	data, err := fs.ToTxtar("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fixture[len("Comments before the first file are ignored.\n"):]
+	if string(data) != want {
+		t.Errorf("wrong archive:\n%s", memfs.UnifiedDiff("archive", []byte(want), data))
+	}
+
+	data, err = fs.ToTxtar("/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "-- empty/ --\n-- private/ mode=0700 --\n-- private/key mode=0600 --\nsecret\n"
+	if string(data) != want {
+		t.Errorf("wrong archive of a subtree:\n%s", data)
+	}
+}
+
+func TestTxtarErrors(t *testing.T) {
+	for _, archive := range []string{
+		"-- link -> target --\ncontent\n",
+		"-- file mode=9 --\n",
+		"-- file mode=010000 --\n",
+		"-- /abs --\n",
+	} {
+		_, err := memfs.FromTxtar([]byte(archive))
+		if !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("%q: expected EINVAL, got %v", archive, err)
+		}
+	}
+	_, err := memfs.FromTxtar([]byte("-- file --\n-- file --\n"))
+	if !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("duplicate file: expected EEXIST, got %v", err)
+	}
+
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/nested", "-- marker --\n")
+	_, err = fs.ToTxtar("/")
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("expected EINVAL, got %v", err)
+	}
+}
+
+func TestTxtarZeroMode(t *testing.T) {
+	archive := "-- zero mode=0000 --\n"
+	fs, err := memfs.FromTxtar([]byte(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Lstat("/zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0 {
+		t.Errorf("wrong mode %s", info.Mode())
+	}
+	data, err := fs.ToTxtar("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != archive {
+		t.Errorf("wrong archive:\n%s", data)
+	}
+}
+
+func TestTxtarReadOnly(t *testing.T) {
+	archive := "-- locked/ mode=0000 --\n-- locked/key mode=0000 --\nsecret\n-- ro/ mode=0555 --\n-- ro/f mode=0444 --\nx\n"
+	fs, err := memfs.FromTxtar([]byte(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]os.FileMode{
+		"/locked":     os.ModeDir,
+		"/locked/key": 0,
+		"/ro":         os.ModeDir | 0555,
+		"/ro/f":       0444,
+	} {
+		info, err := fs.Lstat(name)
+		if err != nil {
+			t.Errorf("lstat %s: %v", name, err)
+		} else if info.Mode() != mode {
+			t.Errorf("%s: wrong mode %s, want %s", name, info.Mode(), mode)
+		}
+	}
+
+	// the archive is read back whatever the credentials
+	user := fs.View()
+	user.Uid, user.Gid = 1000, 1000
+	data, err := user.ToTxtar("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != archive {
+		t.Errorf("wrong archive:\n%s", memfs.UnifiedDiff("archive", []byte(archive), data))
+	}
+}
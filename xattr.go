@@ -0,0 +1,349 @@
+package memfs
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/inode"
+)
+
+// Flags for Setxattr, matching the values used by setxattr(2) on Linux.
+const (
+	XATTR_CREATE  = 0x1 // fail if the attribute already exists.
+	XATTR_REPLACE = 0x2 // fail if the attribute does not exist.
+)
+
+// Extended attribute size limits, matching the Linux kernel limits.
+const (
+	XATTR_NAME_MAX = 255   // maximum length of an attribute name.
+	XATTR_SIZE_MAX = 65536 // maximum size of an attribute value.
+	XATTR_LIST_MAX = 65536 // maximum size of an attribute name list.
+)
+
+// xattrNamespaces lists the attribute name prefixes memfs accepts.
+var xattrNamespaces = []string{"user.", "trusted.", "security."}
+
+// Setxattr sets the value of the extended attribute attr on the named file,
+// following symbolic links.
+func (fs *FileSystem) Setxattr(path, attr string, data []byte, flags int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.setxattr("setxattr", path, true, attr, data, flags)
+}
+
+// Lsetxattr is like Setxattr but does not follow a final symbolic link.
+func (fs *FileSystem) Lsetxattr(path, attr string, data []byte, flags int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.setxattr("lsetxattr", path, false, attr, data, flags)
+}
+
+// Getxattr copies the value of the extended attribute attr of the named file
+// into dest, following symbolic links, and returns the size of the value. If
+// dest is empty only the size is returned.
+func (fs *FileSystem) Getxattr(path, attr string, dest []byte) (int, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.getxattr("getxattr", path, true, attr, dest)
+}
+
+// Lgetxattr is like Getxattr but does not follow a final symbolic link.
+func (fs *FileSystem) Lgetxattr(path, attr string, dest []byte) (int, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.getxattr("lgetxattr", path, false, attr, dest)
+}
+
+// Listxattr copies the NUL terminated names of the extended attributes of the
+// named file into dest, following symbolic links, and returns the size of the
+// list. If dest is empty only the size is returned.
+func (fs *FileSystem) Listxattr(path string, dest []byte) (int, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.listxattr("listxattr", path, true, dest)
+}
+
+// Llistxattr is like Listxattr but does not follow a final symbolic link.
+func (fs *FileSystem) Llistxattr(path string, dest []byte) (int, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.listxattr("llistxattr", path, false, dest)
+}
+
+// Removexattr removes the extended attribute attr from the named file,
+// following symbolic links.
+func (fs *FileSystem) Removexattr(path, attr string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.removexattr("removexattr", path, true, attr)
+}
+
+// Lremovexattr is like Removexattr but does not follow a final symbolic link.
+func (fs *FileSystem) Lremovexattr(path, attr string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.removexattr("lremovexattr", path, false, attr)
+}
+
+func (fs *FileSystem) setxattr(op, path string, follow bool, attr string, data []byte, flags int) error {
+	node, err := fs.resolve(path, follow)
+	if err != nil {
+		return &os.PathError{Op: op, Path: path, Err: err}
+	}
+	err = fs.setNodeXattr(node, attr, data, flags)
+	if err != nil {
+		return &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return nil
+}
+
+func (fs *FileSystem) getxattr(op, path string, follow bool, attr string, dest []byte) (int, error) {
+	node, err := fs.resolve(path, follow)
+	if err != nil {
+		return 0, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	n, err := fs.getNodeXattr(node, attr, dest)
+	if err != nil {
+		return 0, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return n, nil
+}
+
+func (fs *FileSystem) listxattr(op, path string, follow bool, dest []byte) (int, error) {
+	node, err := fs.resolve(path, follow)
+	if err != nil {
+		return 0, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	n, err := fs.listNodeXattr(node, dest)
+	if err != nil {
+		return 0, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return n, nil
+}
+
+func (fs *FileSystem) removexattr(op, path string, follow bool, attr string) error {
+	node, err := fs.resolve(path, follow)
+	if err != nil {
+		return &os.PathError{Op: op, Path: path, Err: err}
+	}
+	err = fs.removeNodeXattr(node, attr)
+	if err != nil {
+		return &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return nil
+}
+
+// checkXattrName validates the name of an extended attribute.
+func checkXattrName(attr string) error {
+	if len(attr) == 0 || len(attr) > XATTR_NAME_MAX {
+		return syscall.ERANGE
+	}
+	for _, ns := range xattrNamespaces {
+		if strings.HasPrefix(attr, ns) {
+			return nil
+		}
+	}
+	return syscall.EOPNOTSUPP
+}
+
+func (fs *FileSystem) setNodeXattr(node *inode.Inode, attr string, data []byte, flags int) error {
+	if flags&^(XATTR_CREATE|XATTR_REPLACE) != 0 {
+		return syscall.EINVAL
+	}
+	err := checkXattrName(attr)
+	if err != nil {
+		return err
+	}
+	if fs.rdonly.Load() {
+		return syscall.EROFS
+	}
+	if len(data) > XATTR_SIZE_MAX {
+		return syscall.E2BIG
+	}
+
+	// Like Linux, user attributes are only permitted on regular files and
+	// directories.
+	if strings.HasPrefix(attr, "user.") && !node.Mode.IsRegular() && !node.IsDir() {
+		return syscall.EPERM
+	}
+	err = fs.mayWriteXattr(node, attr)
+	if err != nil {
+		return err
+	}
+
+	attrs := fs.xattrs[node.Ino]
+	_, exists := attrs[attr]
+	if exists && flags&XATTR_CREATE != 0 {
+		return syscall.EEXIST
+	}
+	if !exists && flags&XATTR_REPLACE != 0 {
+		return errNoAttr
+	}
+	if !exists && xattrListSize(attrs)+len(attr)+1 > XATTR_LIST_MAX {
+		return syscall.ENOSPC
+	}
+
+	if attrs == nil {
+		attrs = make(map[string][]byte)
+		fs.xattrs[node.Ino] = attrs
+	}
+	value := make([]byte, len(data))
+	copy(value, data)
+	attrs[attr] = value
+	fs.changed(node)
+	return nil
+}
+
+func (fs *FileSystem) getNodeXattr(node *inode.Inode, attr string, dest []byte) (int, error) {
+	err := checkXattrName(attr)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := fs.xattrs[node.Ino][attr]
+	if !ok {
+		return 0, errNoAttr
+	}
+	if len(dest) == 0 {
+		return len(value), nil
+	}
+	if len(dest) < len(value) {
+		return 0, syscall.ERANGE
+	}
+	return copy(dest, value), nil
+}
+
+func (fs *FileSystem) listNodeXattr(node *inode.Inode, dest []byte) (int, error) {
+	attrs := fs.xattrs[node.Ino]
+	size := xattrListSize(attrs)
+	if len(dest) == 0 {
+		return size, nil
+	}
+	if len(dest) < size {
+		return 0, syscall.ERANGE
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	n := 0
+	for _, name := range names {
+		n += copy(dest[n:], name)
+		dest[n] = 0
+		n++
+	}
+	return n, nil
+}
+
+func (fs *FileSystem) removeNodeXattr(node *inode.Inode, attr string) error {
+	err := checkXattrName(attr)
+	if err != nil {
+		return err
+	}
+	if fs.rdonly.Load() {
+		return syscall.EROFS
+	}
+	err = fs.mayWriteXattr(node, attr)
+	if err != nil {
+		return err
+	}
+	attrs := fs.xattrs[node.Ino]
+	if _, ok := attrs[attr]; !ok {
+		return errNoAttr
+	}
+	delete(attrs, attr)
+	if len(attrs) == 0 {
+		delete(fs.xattrs, node.Ino)
+	}
+	fs.changed(node)
+	return nil
+}
+
+// mayWriteXattr checks that the credentials of the FileSystem may set or
+// remove the extended attribute attr of node. As on Linux, trusted attributes
+// are reserved to root and other attributes require write permission.
+func (fs *FileSystem) mayWriteXattr(node *inode.Inode, attr string) error {
+	if strings.HasPrefix(attr, "trusted.") {
+		if fs.Uid != 0 {
+			return syscall.EPERM
+		}
+		return nil
+	}
+	if !fs.access(node, absfs.OS_WRITE) {
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// xattrListSize returns the size of the NUL terminated list of attribute
+// names as returned by Listxattr.
+func xattrListSize(attrs map[string][]byte) int {
+	size := 0
+	for name := range attrs {
+		size += len(name) + 1
+	}
+	return size
+}
+
+// Setxattr sets the value of the extended attribute attr on the open file.
+func (f *File) Setxattr(attr string, data []byte, flags int) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.node == nil {
+		return &os.PathError{Op: "fsetxattr", Path: f.name, Err: syscall.EBADF}
+	}
+	err := f.fs.setNodeXattr(f.node, attr, data, flags)
+	if err != nil {
+		return &os.PathError{Op: "fsetxattr", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// Getxattr copies the value of the extended attribute attr of the open file
+// into dest and returns the size of the value.
+func (f *File) Getxattr(attr string, dest []byte) (int, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if f.node == nil {
+		return 0, &os.PathError{Op: "fgetxattr", Path: f.name, Err: syscall.EBADF}
+	}
+	n, err := f.fs.getNodeXattr(f.node, attr, dest)
+	if err != nil {
+		return 0, &os.PathError{Op: "fgetxattr", Path: f.name, Err: err}
+	}
+	return n, nil
+}
+
+// Listxattr copies the NUL terminated names of the extended attributes of the
+// open file into dest and returns the size of the list.
+func (f *File) Listxattr(dest []byte) (int, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if f.node == nil {
+		return 0, &os.PathError{Op: "flistxattr", Path: f.name, Err: syscall.EBADF}
+	}
+	n, err := f.fs.listNodeXattr(f.node, dest)
+	if err != nil {
+		return 0, &os.PathError{Op: "flistxattr", Path: f.name, Err: err}
+	}
+	return n, nil
+}
+
+// Removexattr removes the extended attribute attr from the open file.
+func (f *File) Removexattr(attr string) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.node == nil {
+		return &os.PathError{Op: "fremovexattr", Path: f.name, Err: syscall.EBADF}
+	}
+	err := f.fs.removeNodeXattr(f.node, attr)
+	if err != nil {
+		return &os.PathError{Op: "fremovexattr", Path: f.name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package memfs
+
+import "syscall"
+
+// errNoAttr is the error for a missing extended attribute, ENOATTR on the BSDs
+// and macOS.
+var errNoAttr error = syscall.ENOATTR
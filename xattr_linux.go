@@ -0,0 +1,6 @@
+package memfs
+
+import "syscall"
+
+// errNoAttr is the error for a missing extended attribute, ENODATA on Linux.
+var errNoAttr error = syscall.ENODATA
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package memfs
+
+import "syscall"
+
+// errNoAttr is the error for a missing extended attribute. Systems without
+// extended attributes may not define ENODATA or ENOATTR, so ENOENT is used.
+var errNoAttr error = syscall.ENOENT
@@ -0,0 +1,246 @@
+package memfs_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+)
+
+func TestXattr(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	err = fs.Setxattr("/file.txt", "user.mime_type", []byte("text/plain"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := fs.Getxattr("/file.txt", "user.mime_type", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len("text/plain") {
+		t.Errorf("wrong size: %d", n)
+	}
+
+	buf := make([]byte, n)
+	n, err = fs.Getxattr("/file.txt", "user.mime_type", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "text/plain" {
+		t.Errorf("wrong value: %q", buf[:n])
+	}
+
+	_, err = fs.Getxattr("/file.txt", "user.mime_type", make([]byte, 2))
+	if !errors.Is(err, syscall.ERANGE) {
+		t.Errorf("expected ERANGE, got %v", err)
+	}
+
+	_, err = fs.Getxattr("/file.txt", "user.missing", buf)
+	if !errors.Is(err, memfs.ErrNoAttr) {
+		t.Errorf("expected %v, got %v", memfs.ErrNoAttr, err)
+	}
+	if _, ok := err.(*os.PathError); !ok {
+		t.Errorf("expected *os.PathError, got %T", err)
+	}
+
+	err = fs.Setxattr("/file.txt", "user.mime_type", []byte("text/html"), memfs.XATTR_CREATE)
+	if !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("expected EEXIST, got %v", err)
+	}
+	err = fs.Setxattr("/file.txt", "user.hash", []byte("abc"), memfs.XATTR_REPLACE)
+	if !errors.Is(err, memfs.ErrNoAttr) {
+		t.Errorf("expected %v, got %v", memfs.ErrNoAttr, err)
+	}
+	err = fs.Setxattr("/file.txt", "user.hash", []byte("abc"), memfs.XATTR_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Setxattr("/file.txt", "bogus.name", nil, 0)
+	if !errors.Is(err, syscall.EOPNOTSUPP) {
+		t.Errorf("expected EOPNOTSUPP, got %v", err)
+	}
+	err = fs.Setxattr("/file.txt", "user."+strings.Repeat("x", memfs.XATTR_NAME_MAX), nil, 0)
+	if !errors.Is(err, syscall.ERANGE) {
+		t.Errorf("expected ERANGE, got %v", err)
+	}
+	err = fs.Setxattr("/file.txt", "user.big", make([]byte, memfs.XATTR_SIZE_MAX+1), 0)
+	if !errors.Is(err, syscall.E2BIG) {
+		t.Errorf("expected E2BIG, got %v", err)
+	}
+
+	n, err = fs.Listxattr("/file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := make([]byte, n)
+	n, err = fs.Listxattr("/file.txt", list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("user.hash\x00user.mime_type\x00"); !bytes.Equal(list[:n], want) {
+		t.Errorf("wrong list: %q, expected %q", list[:n], want)
+	}
+
+	err = fs.Removexattr("/file.txt", "user.hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Removexattr("/file.txt", "user.hash")
+	if !errors.Is(err, memfs.ErrNoAttr) {
+		t.Errorf("expected %v, got %v", memfs.ErrNoAttr, err)
+	}
+
+	_, err = fs.Getxattr("/missing.txt", "user.hash", nil)
+	if !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("expected ENOENT, got %v", err)
+	}
+}
+
+func TestXattrSymlink(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/target.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	err = fs.Symlink("/target.txt", "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Setxattr("/link", "user.followed", []byte("yes"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.Getxattr("/target.txt", "user.followed", nil)
+	if err != nil {
+		t.Errorf("Setxattr did not follow the link: %v", err)
+	}
+	_, err = fs.Lgetxattr("/link", "user.followed", nil)
+	if !errors.Is(err, memfs.ErrNoAttr) {
+		t.Errorf("expected %v, got %v", memfs.ErrNoAttr, err)
+	}
+
+	err = fs.Lsetxattr("/link", "user.denied", []byte("no"), 0)
+	if !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected EPERM, got %v", err)
+	}
+	err = fs.Lsetxattr("/link", "trusted.allowed", []byte("yes"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := fs.Llistxattr("/link", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len("trusted.allowed\x00") {
+		t.Errorf("wrong list size: %d", n)
+	}
+}
+
+func TestFileXattr(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf := f.(*memfs.File)
+
+	err = mf.Setxattr("security.label", []byte("unconfined"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := mf.Getxattr("security.label", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "unconfined" {
+		t.Errorf("wrong value: %q", buf[:n])
+	}
+	f.Close()
+
+	_, err = mf.Getxattr("security.label", buf)
+	if !errors.Is(err, syscall.EBADF) {
+		t.Errorf("expected EBADF, got %v", err)
+	}
+
+	n, err = fs.Getxattr("/file.txt", "security.label", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "unconfined" {
+		t.Errorf("wrong value: %q", buf[:n])
+	}
+}
+
+func TestXattrPermission(t *testing.T) {
+	fs, err := memfs.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Uid, fs.Gid = 0, 0
+	writeFile(t, fs, "/root", "")
+	writeFile(t, fs, "/mine", "")
+	if err := fs.Chown("/mine", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Setxattr("/root", "user.test", []byte("root"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	user := fs.View()
+	user.Uid, user.Gid = 1000, 1000
+	for _, tc := range []struct {
+		op   string
+		fn   func() error
+		want error
+	}{
+		{"setxattr", func() error { return user.Setxattr("/root", "user.test", []byte("user"), 0) }, syscall.EACCES},
+		{"setxattr", func() error { return user.Setxattr("/root", "user.other", nil, 0) }, syscall.EACCES},
+		{"removexattr", func() error { return user.Removexattr("/root", "user.test") }, syscall.EACCES},
+		{"setxattr", func() error { return user.Setxattr("/mine", "trusted.test", nil, 0) }, syscall.EPERM},
+	} {
+		err := tc.fn()
+		var perr *os.PathError
+		if !errors.As(err, &perr) || perr.Op != tc.op || !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.op, tc.want, err)
+		}
+	}
+	buf := make([]byte, 16)
+	if n, err := fs.Getxattr("/root", "user.test", buf); err != nil || string(buf[:n]) != "root" {
+		t.Errorf("attribute changed without write access: %q, %v", buf[:n], err)
+	}
+
+	// the owner may change the attributes of a file it can write
+	if err := user.Setxattr("/mine", "user.test", []byte("user"), 0); err != nil {
+		t.Error(err)
+	}
+	if err := user.Removexattr("/mine", "user.test"); err != nil {
+		t.Error(err)
+	}
+}